@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidAirWaybillNumber = errors.New("invalid air waybill number")
+
+// ParseAirWaybillNumber checks an IATA air waybill number such as 160-12345675 and returns the 3 digit airline
+// prefix and the 8 digit serial number. Hyphens and spaces are ignored. Spreadsheets drop the leading zeros of
+// numeric cells, numbers with 9 or 10 digits are padded so that prefixes such as 020 survive.
+func ParseAirWaybillNumber(number string) (prefix, serial string, err error) {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(number))
+	if reason := checkAirWaybillNumber(digits); reason != "" {
+		return "", "", fmt.Errorf("%w %q: %s", ErrInvalidAirWaybillNumber, number, reason)
+	}
+	digits = fmt.Sprintf("%011s", digits)
+	return digits[:3], digits[3:], nil
+}
+
+// checkAirWaybillNumber returns why digits are not an air waybill number or "" if they are. The last digit of the
+// serial number is a check digit, the remainder of the first 7 digits divided by 7.
+func checkAirWaybillNumber(digits string) string {
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "must only contain digits"
+		}
+	}
+	if len(digits) < 9 || len(digits) > 11 {
+		return "must have 11 digits"
+	}
+	serial := digits[len(digits)-8:]
+	remainder := 0
+	for _, r := range serial[:7] {
+		remainder = (remainder*10 + int(r-'0')) % 7
+	}
+	if checkDigit := int(serial[7] - '0'); checkDigit != remainder {
+		return fmt.Sprintf("check digit is %d, expected %d", checkDigit, remainder)
+	}
+	return ""
+}
+
+// airlineByPrefix returns the airline that issues air waybills with the prefix, or nil if the prefix is unknown.
+func airlineByPrefix(prefix string) *airline {
+	return airlinesByPrefix[prefix]
+}
+
+func newCarrier(carrier *airline) *Party {
+	return &Party{
+		PartyDetails: &LogisticsAgent{
+			Name:          carrier.Name,
+			AirlineCode:   carrier.Code,
+			AirlinePrefix: carrier.Prefix,
+			Type:          "cargo:Carrier",
+		},
+		Type: "cargo:Party",
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckAirWaybillNumber(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   string
+	}{
+		{"02012345675", ""},
+		{"16012345675", ""},
+		{"17600000000", ""},
+		{"2012345675", ""},
+		{"012345675", ""},
+		{"02012345670", "check digit is 0, expected 5"},
+		{"02099999996", "check digit is 6, expected 2"},
+		{"0201234567", "check digit is 7, expected 4"},
+		{"12345675", "must have 11 digits"},
+		{"020123456750", "must have 11 digits"},
+		{"020-12345675", "must only contain digits"},
+		{"", "must have 11 digits"},
+	}
+	for _, test := range tests {
+		if got := checkAirWaybillNumber(test.digits); got != test.want {
+			t.Errorf("checkAirWaybillNumber(%q) = %q, want %q", test.digits, got, test.want)
+		}
+	}
+}
+
+func TestParseAirWaybillNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		prefix string
+		serial string
+		err    error
+	}{
+		{"020-12345675", "020", "12345675", nil},
+		{" 160 1234 5675 ", "160", "12345675", nil},
+		{"2012345675", "020", "12345675", nil},
+		{"020-12345670", "", "", ErrInvalidAirWaybillNumber},
+		{"AWB 020-12345675", "", "", ErrInvalidAirWaybillNumber},
+	}
+	for _, test := range tests {
+		prefix, serial, err := ParseAirWaybillNumber(test.number)
+		if prefix != test.prefix || serial != test.serial || !errors.Is(err, test.err) {
+			t.Errorf("ParseAirWaybillNumber(%q) = %q, %q, %v, want %q, %q, %v", test.number, prefix, serial, err, test.prefix, test.serial, test.err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiNamespace = "https://onerecord.iata.org/ns/api#"
+	xsdNamespace = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// errUnsupportedChange is returned for differences that cannot be expressed as change operations, e.g. an
+// embedded object that is added or removed. The logistics object is published again instead.
+var errUnsupportedChange = errors.New("change cannot be expressed as change request")
+
+// Change is a ONE Record change request for one logistics object.
+type Change struct {
+	Context         *ChangeContext `json:"@context"`
+	Type            string         `json:"@type"`
+	LogisticsObject *Reference     `json:"api:hasLogisticsObject"`
+	Description     string         `json:"api:hasDescription,omitempty"`
+	Operations      []*Operation   `json:"api:hasOperation"`
+	Revision        *TypedLiteral  `json:"api:hasRevision"`
+}
+
+type ChangeContext struct {
+	Cargo string `json:"cargo"`
+	API   string `json:"api"`
+}
+
+type Reference struct {
+	ID   string `json:"@id"`
+	Type string `json:"@type,omitempty"`
+}
+
+type TypedLiteral struct {
+	Type  string `json:"@type"`
+	Value string `json:"@value"`
+}
+
+type Operation struct {
+	Type      string           `json:"@type"`
+	Op        *Reference       `json:"api:op"`
+	Subject   string           `json:"api:s"`
+	Predicate string           `json:"api:p"`
+	Object    *OperationObject `json:"api:o"`
+}
+
+type OperationObject struct {
+	Type     string `json:"@type"`
+	Datatype string `json:"api:hasDatatype"`
+	Value    string `json:"api:hasValue"`
+}
+
+const (
+	operationAdd    = "api:ADD"
+	operationDelete = "api:DELETE"
+)
+
+func newChange(uri string, revision int, operations []*Operation) *Change {
+	return &Change{
+		Context:         &ChangeContext{Cargo: cargoNamespace, API: apiNamespace},
+		Type:            "api:Change",
+		LogisticsObject: &Reference{ID: uri},
+		Description:     "manifest uploaded again",
+		Operations:      operations,
+		Revision:        &TypedLiteral{Type: xsdNamespace + "positiveInteger", Value: strconv.Itoa(revision)},
+	}
+}
+
+func newOperation(op, subject, predicate, datatype, value string) *Operation {
+	return &Operation{
+		Type:      "api:Operation",
+		Op:        &Reference{ID: op},
+		Subject:   subject,
+		Predicate: expandTerm(predicate),
+		Object:    &OperationObject{Type: "api:OperationObject", Datatype: datatype, Value: value},
+	}
+}
+
+// expandTerm turns a compact term such as "cargo:pieces" into an IRI.
+func expandTerm(term string) string {
+	if local, ok := strings.CutPrefix(term, "cargo:"); ok {
+		return cargoNamespace + local
+	}
+	return term
+}
+
+// literalDatatype returns the XSD datatype of a JSON value.
+func literalDatatype(value any) string {
+	switch value.(type) {
+	case float64:
+		return xsdNamespace + "double"
+	case bool:
+		return xsdNamespace + "boolean"
+	}
+	return xsdNamespace + "string"
+}
+
+// propertyChange describes new and removed values of a literal property. Path leads from the logistics object
+// to the embedded object that holds the property, it is empty for properties of the logistics object itself.
+type propertyChange struct {
+	Path     []pathSegment
+	Property string
+	Removed  []any
+	Added    []any
+}
+
+type pathSegment struct {
+	Property string
+	// Previous is the embedded object as it was published, it identifies the object within an array.
+	Previous map[string]any
+}
+
+// diffObjects compares two published bodies of a logistics object and returns the changed literal properties.
+func diffObjects(previous, current map[string]any, path []pathSegment) ([]propertyChange, error) {
+	var changes []propertyChange
+	for _, key := range unionKeys(previous, current) {
+		if strings.HasPrefix(key, "@") {
+			if key == "@type" && fmt.Sprint(previous[key]) != fmt.Sprint(current[key]) {
+				return nil, fmt.Errorf("%w: type changed", errUnsupportedChange)
+			}
+			continue
+		}
+		previousValue, currentValue := previous[key], current[key]
+
+		previousObject, previousIsObject := previousValue.(map[string]any)
+		currentObject, currentIsObject := currentValue.(map[string]any)
+		previousObjects, previousIsObjects := embeddedObjects(previousValue)
+		currentObjects, currentIsObjects := embeddedObjects(currentValue)
+
+		switch {
+		case previousIsObject && currentIsObject:
+			objectChanges, err := diffObjects(previousObject, currentObject, appendPath(path, key, previousObject))
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, objectChanges...)
+		case previousIsObjects && currentIsObjects && len(previousObjects) == len(currentObjects):
+			for i := range previousObjects {
+				objectChanges, err := diffObjects(previousObjects[i], currentObjects[i], appendPath(path, key, previousObjects[i]))
+				if err != nil {
+					return nil, err
+				}
+				changes = append(changes, objectChanges...)
+			}
+		case previousIsObject || currentIsObject || previousIsObjects || currentIsObjects:
+			if fmt.Sprint(previousValue) != fmt.Sprint(currentValue) {
+				return nil, fmt.Errorf("%w: embedded %s added or removed", errUnsupportedChange, key)
+			}
+		default:
+			removed, added := diffLiterals(literals(previousValue), literals(currentValue))
+			if len(removed) > 0 || len(added) > 0 {
+				changes = append(changes, propertyChange{Path: path, Property: key, Removed: removed, Added: added})
+			}
+		}
+	}
+	return changes, nil
+}
+
+func appendPath(path []pathSegment, property string, previous map[string]any) []pathSegment {
+	return append(path[:len(path):len(path)], pathSegment{Property: property, Previous: previous})
+}
+
+func unionKeys(a, b map[string]any) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// embeddedObjects returns the objects of an array of embedded objects.
+func embeddedObjects(value any) ([]map[string]any, bool) {
+	values, ok := value.([]any)
+	if !ok || len(values) == 0 {
+		return nil, false
+	}
+	objects := make([]map[string]any, 0, len(values))
+	for _, value := range values {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		objects = append(objects, object)
+	}
+	return objects, true
+}
+
+// literals returns the values of a literal or an array of literals.
+func literals(value any) []any {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return value
+	case map[string]any:
+		// a typed literal as returned by the server
+		if literal, ok := value["@value"]; ok {
+			return []any{literal}
+		}
+	}
+	return []any{value}
+}
+
+// diffLiterals compares values as sets, properties in RDF have no order.
+func diffLiterals(previous, current []any) (removed, added []any) {
+	contains := func(values []any, value any) bool {
+		for _, v := range values {
+			if fmt.Sprint(v) == fmt.Sprint(value) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, value := range previous {
+		if !contains(current, value) {
+			removed = append(removed, value)
+		}
+	}
+	for _, value := range current {
+		if !contains(previous, value) {
+			added = append(added, value)
+		}
+	}
+	return removed, added
+}
+
+// changeOperations turns property changes into operations. The current logistics object from the server is needed
+// to find the @id of embedded objects, they are the subject of the operations on their properties.
+func changeOperations(uri string, current map[string]any, changes []propertyChange) ([]*Operation, error) {
+	var operations []*Operation
+	for _, change := range changes {
+		subject := uri
+		node := current
+		for _, segment := range change.Path {
+			embedded := findEmbeddedObject(node, segment)
+			if embedded == nil {
+				return nil, fmt.Errorf("%w: embedded %s not found on server", errUnsupportedChange, segment.Property)
+			}
+			id, ok := embedded["@id"].(string)
+			if !ok || id == "" {
+				return nil, fmt.Errorf("%w: embedded %s has no @id", errUnsupportedChange, segment.Property)
+			}
+			subject, node = id, embedded
+		}
+
+		for _, value := range change.Removed {
+			operations = append(operations, newOperation(operationDelete, subject, change.Property, literalDatatype(value), fmt.Sprint(value)))
+		}
+		for _, value := range change.Added {
+			operations = append(operations, newOperation(operationAdd, subject, change.Property, literalDatatype(value), fmt.Sprint(value)))
+		}
+	}
+	return operations, nil
+}
+
+// serverProperty reads a property by its compact term or by its IRI.
+func serverProperty(node map[string]any, term string) any {
+	if value, ok := node[term]; ok {
+		return value
+	}
+	return node[expandTerm(term)]
+}
+
+// findEmbeddedObject returns the embedded object of the server node that matches the published one best.
+func findEmbeddedObject(node map[string]any, segment pathSegment) map[string]any {
+	var candidates []map[string]any
+	switch value := serverProperty(node, segment.Property).(type) {
+	case map[string]any:
+		candidates = []map[string]any{value}
+	case []any:
+		for _, value := range value {
+			if object, ok := value.(map[string]any); ok {
+				candidates = append(candidates, object)
+			}
+		}
+	}
+
+	var best map[string]any
+	bestScore := -1
+	for _, candidate := range candidates {
+		score := 0
+		for key, value := range segment.Previous {
+			if strings.HasPrefix(key, "@") {
+				continue
+			}
+			if _, added := diffLiterals(literals(serverProperty(candidate, key)), literals(value)); len(added) == 0 {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type isoCountry struct {
+	Alpha2 string
+	Alpha3 string
+	Name   string
+}
+
+// isoCountries is the ISO 3166-1 country list.
+var isoCountries = []isoCountry{
+	{"AD", "AND", "Andorra"},
+	{"AE", "ARE", "United Arab Emirates"},
+	{"AF", "AFG", "Afghanistan"},
+	{"AG", "ATG", "Antigua and Barbuda"},
+	{"AI", "AIA", "Anguilla"},
+	{"AL", "ALB", "Albania"},
+	{"AM", "ARM", "Armenia"},
+	{"AO", "AGO", "Angola"},
+	{"AQ", "ATA", "Antarctica"},
+	{"AR", "ARG", "Argentina"},
+	{"AS", "ASM", "American Samoa"},
+	{"AT", "AUT", "Austria"},
+	{"AU", "AUS", "Australia"},
+	{"AW", "ABW", "Aruba"},
+	{"AX", "ALA", "Åland Islands"},
+	{"AZ", "AZE", "Azerbaijan"},
+	{"BA", "BIH", "Bosnia and Herzegovina"},
+	{"BB", "BRB", "Barbados"},
+	{"BD", "BGD", "Bangladesh"},
+	{"BE", "BEL", "Belgium"},
+	{"BF", "BFA", "Burkina Faso"},
+	{"BG", "BGR", "Bulgaria"},
+	{"BH", "BHR", "Bahrain"},
+	{"BI", "BDI", "Burundi"},
+	{"BJ", "BEN", "Benin"},
+	{"BL", "BLM", "Saint Barthélemy"},
+	{"BM", "BMU", "Bermuda"},
+	{"BN", "BRN", "Brunei Darussalam"},
+	{"BO", "BOL", "Bolivia, Plurinational State of"},
+	{"BQ", "BES", "Bonaire, Sint Eustatius and Saba"},
+	{"BR", "BRA", "Brazil"},
+	{"BS", "BHS", "Bahamas"},
+	{"BT", "BTN", "Bhutan"},
+	{"BV", "BVT", "Bouvet Island"},
+	{"BW", "BWA", "Botswana"},
+	{"BY", "BLR", "Belarus"},
+	{"BZ", "BLZ", "Belize"},
+	{"CA", "CAN", "Canada"},
+	{"CC", "CCK", "Cocos (Keeling) Islands"},
+	{"CD", "COD", "Congo, The Democratic Republic of the"},
+	{"CF", "CAF", "Central African Republic"},
+	{"CG", "COG", "Congo"},
+	{"CH", "CHE", "Switzerland"},
+	{"CI", "CIV", "Côte d'Ivoire"},
+	{"CK", "COK", "Cook Islands"},
+	{"CL", "CHL", "Chile"},
+	{"CM", "CMR", "Cameroon"},
+	{"CN", "CHN", "China"},
+	{"CO", "COL", "Colombia"},
+	{"CR", "CRI", "Costa Rica"},
+	{"CU", "CUB", "Cuba"},
+	{"CV", "CPV", "Cabo Verde"},
+	{"CW", "CUW", "Curaçao"},
+	{"CX", "CXR", "Christmas Island"},
+	{"CY", "CYP", "Cyprus"},
+	{"CZ", "CZE", "Czechia"},
+	{"DE", "DEU", "Germany"},
+	{"DJ", "DJI", "Djibouti"},
+	{"DK", "DNK", "Denmark"},
+	{"DM", "DMA", "Dominica"},
+	{"DO", "DOM", "Dominican Republic"},
+	{"DZ", "DZA", "Algeria"},
+	{"EC", "ECU", "Ecuador"},
+	{"EE", "EST", "Estonia"},
+	{"EG", "EGY", "Egypt"},
+	{"EH", "ESH", "Western Sahara"},
+	{"ER", "ERI", "Eritrea"},
+	{"ES", "ESP", "Spain"},
+	{"ET", "ETH", "Ethiopia"},
+	{"FI", "FIN", "Finland"},
+	{"FJ", "FJI", "Fiji"},
+	{"FK", "FLK", "Falkland Islands (Malvinas)"},
+	{"FM", "FSM", "Micronesia, Federated States of"},
+	{"FO", "FRO", "Faroe Islands"},
+	{"FR", "FRA", "France"},
+	{"GA", "GAB", "Gabon"},
+	{"GB", "GBR", "United Kingdom"},
+	{"GD", "GRD", "Grenada"},
+	{"GE", "GEO", "Georgia"},
+	{"GF", "GUF", "French Guiana"},
+	{"GG", "GGY", "Guernsey"},
+	{"GH", "GHA", "Ghana"},
+	{"GI", "GIB", "Gibraltar"},
+	{"GL", "GRL", "Greenland"},
+	{"GM", "GMB", "Gambia"},
+	{"GN", "GIN", "Guinea"},
+	{"GP", "GLP", "Guadeloupe"},
+	{"GQ", "GNQ", "Equatorial Guinea"},
+	{"GR", "GRC", "Greece"},
+	{"GS", "SGS", "South Georgia and the South Sandwich Islands"},
+	{"GT", "GTM", "Guatemala"},
+	{"GU", "GUM", "Guam"},
+	{"GW", "GNB", "Guinea-Bissau"},
+	{"GY", "GUY", "Guyana"},
+	{"HK", "HKG", "Hong Kong"},
+	{"HM", "HMD", "Heard Island and McDonald Islands"},
+	{"HN", "HND", "Honduras"},
+	{"HR", "HRV", "Croatia"},
+	{"HT", "HTI", "Haiti"},
+	{"HU", "HUN", "Hungary"},
+	{"ID", "IDN", "Indonesia"},
+	{"IE", "IRL", "Ireland"},
+	{"IL", "ISR", "Israel"},
+	{"IM", "IMN", "Isle of Man"},
+	{"IN", "IND", "India"},
+	{"IO", "IOT", "British Indian Ocean Territory"},
+	{"IQ", "IRQ", "Iraq"},
+	{"IR", "IRN", "Iran, Islamic Republic of"},
+	{"IS", "ISL", "Iceland"},
+	{"IT", "ITA", "Italy"},
+	{"JE", "JEY", "Jersey"},
+	{"JM", "JAM", "Jamaica"},
+	{"JO", "JOR", "Jordan"},
+	{"JP", "JPN", "Japan"},
+	{"KE", "KEN", "Kenya"},
+	{"KG", "KGZ", "Kyrgyzstan"},
+	{"KH", "KHM", "Cambodia"},
+	{"KI", "KIR", "Kiribati"},
+	{"KM", "COM", "Comoros"},
+	{"KN", "KNA", "Saint Kitts and Nevis"},
+	{"KP", "PRK", "Korea, Democratic People's Republic of"},
+	{"KR", "KOR", "Korea, Republic of"},
+	{"KW", "KWT", "Kuwait"},
+	{"KY", "CYM", "Cayman Islands"},
+	{"KZ", "KAZ", "Kazakhstan"},
+	{"LA", "LAO", "Lao People's Democratic Republic"},
+	{"LB", "LBN", "Lebanon"},
+	{"LC", "LCA", "Saint Lucia"},
+	{"LI", "LIE", "Liechtenstein"},
+	{"LK", "LKA", "Sri Lanka"},
+	{"LR", "LBR", "Liberia"},
+	{"LS", "LSO", "Lesotho"},
+	{"LT", "LTU", "Lithuania"},
+	{"LU", "LUX", "Luxembourg"},
+	{"LV", "LVA", "Latvia"},
+	{"LY", "LBY", "Libya"},
+	{"MA", "MAR", "Morocco"},
+	{"MC", "MCO", "Monaco"},
+	{"MD", "MDA", "Moldova, Republic of"},
+	{"ME", "MNE", "Montenegro"},
+	{"MF", "MAF", "Saint Martin (French part)"},
+	{"MG", "MDG", "Madagascar"},
+	{"MH", "MHL", "Marshall Islands"},
+	{"MK", "MKD", "North Macedonia"},
+	{"ML", "MLI", "Mali"},
+	{"MM", "MMR", "Myanmar"},
+	{"MN", "MNG", "Mongolia"},
+	{"MO", "MAC", "Macao"},
+	{"MP", "MNP", "Northern Mariana Islands"},
+	{"MQ", "MTQ", "Martinique"},
+	{"MR", "MRT", "Mauritania"},
+	{"MS", "MSR", "Montserrat"},
+	{"MT", "MLT", "Malta"},
+	{"MU", "MUS", "Mauritius"},
+	{"MV", "MDV", "Maldives"},
+	{"MW", "MWI", "Malawi"},
+	{"MX", "MEX", "Mexico"},
+	{"MY", "MYS", "Malaysia"},
+	{"MZ", "MOZ", "Mozambique"},
+	{"NA", "NAM", "Namibia"},
+	{"NC", "NCL", "New Caledonia"},
+	{"NE", "NER", "Niger"},
+	{"NF", "NFK", "Norfolk Island"},
+	{"NG", "NGA", "Nigeria"},
+	{"NI", "NIC", "Nicaragua"},
+	{"NL", "NLD", "Netherlands"},
+	{"NO", "NOR", "Norway"},
+	{"NP", "NPL", "Nepal"},
+	{"NR", "NRU", "Nauru"},
+	{"NU", "NIU", "Niue"},
+	{"NZ", "NZL", "New Zealand"},
+	{"OM", "OMN", "Oman"},
+	{"PA", "PAN", "Panama"},
+	{"PE", "PER", "Peru"},
+	{"PF", "PYF", "French Polynesia"},
+	{"PG", "PNG", "Papua New Guinea"},
+	{"PH", "PHL", "Philippines"},
+	{"PK", "PAK", "Pakistan"},
+	{"PL", "POL", "Poland"},
+	{"PM", "SPM", "Saint Pierre and Miquelon"},
+	{"PN", "PCN", "Pitcairn"},
+	{"PR", "PRI", "Puerto Rico"},
+	{"PS", "PSE", "Palestine, State of"},
+	{"PT", "PRT", "Portugal"},
+	{"PW", "PLW", "Palau"},
+	{"PY", "PRY", "Paraguay"},
+	{"QA", "QAT", "Qatar"},
+	{"RE", "REU", "Réunion"},
+	{"RO", "ROU", "Romania"},
+	{"RS", "SRB", "Serbia"},
+	{"RU", "RUS", "Russian Federation"},
+	{"RW", "RWA", "Rwanda"},
+	{"SA", "SAU", "Saudi Arabia"},
+	{"SB", "SLB", "Solomon Islands"},
+	{"SC", "SYC", "Seychelles"},
+	{"SD", "SDN", "Sudan"},
+	{"SE", "SWE", "Sweden"},
+	{"SG", "SGP", "Singapore"},
+	{"SH", "SHN", "Saint Helena, Ascension and Tristan da Cunha"},
+	{"SI", "SVN", "Slovenia"},
+	{"SJ", "SJM", "Svalbard and Jan Mayen"},
+	{"SK", "SVK", "Slovakia"},
+	{"SL", "SLE", "Sierra Leone"},
+	{"SM", "SMR", "San Marino"},
+	{"SN", "SEN", "Senegal"},
+	{"SO", "SOM", "Somalia"},
+	{"SR", "SUR", "Suriname"},
+	{"SS", "SSD", "South Sudan"},
+	{"ST", "STP", "Sao Tome and Principe"},
+	{"SV", "SLV", "El Salvador"},
+	{"SX", "SXM", "Sint Maarten (Dutch part)"},
+	{"SY", "SYR", "Syrian Arab Republic"},
+	{"SZ", "SWZ", "Eswatini"},
+	{"TC", "TCA", "Turks and Caicos Islands"},
+	{"TD", "TCD", "Chad"},
+	{"TF", "ATF", "French Southern Territories"},
+	{"TG", "TGO", "Togo"},
+	{"TH", "THA", "Thailand"},
+	{"TJ", "TJK", "Tajikistan"},
+	{"TK", "TKL", "Tokelau"},
+	{"TL", "TLS", "Timor-Leste"},
+	{"TM", "TKM", "Turkmenistan"},
+	{"TN", "TUN", "Tunisia"},
+	{"TO", "TON", "Tonga"},
+	{"TR", "TUR", "Türkiye"},
+	{"TT", "TTO", "Trinidad and Tobago"},
+	{"TV", "TUV", "Tuvalu"},
+	{"TW", "TWN", "Taiwan, Province of China"},
+	{"TZ", "TZA", "Tanzania, United Republic of"},
+	{"UA", "UKR", "Ukraine"},
+	{"UG", "UGA", "Uganda"},
+	{"UM", "UMI", "United States Minor Outlying Islands"},
+	{"US", "USA", "United States"},
+	{"UY", "URY", "Uruguay"},
+	{"UZ", "UZB", "Uzbekistan"},
+	{"VA", "VAT", "Holy See (Vatican City State)"},
+	{"VC", "VCT", "Saint Vincent and the Grenadines"},
+	{"VE", "VEN", "Venezuela, Bolivarian Republic of"},
+	{"VG", "VGB", "Virgin Islands, British"},
+	{"VI", "VIR", "Virgin Islands, U.S."},
+	{"VN", "VNM", "Viet Nam"},
+	{"VU", "VUT", "Vanuatu"},
+	{"WF", "WLF", "Wallis and Futuna"},
+	{"WS", "WSM", "Samoa"},
+	{"YE", "YEM", "Yemen"},
+	{"YT", "MYT", "Mayotte"},
+	{"ZA", "ZAF", "South Africa"},
+	{"ZM", "ZMB", "Zambia"},
+	{"ZW", "ZWE", "Zimbabwe"},
+}
+
+// isoCurrencyCodes is the ISO 4217 list of active currency codes.
+var isoCurrencyCodes = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true,
+	"AWG": true, "AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true,
+	"BMD": true, "BND": true, "BOB": true, "BOV": true, "BRL": true, "BSD": true, "BTN": true, "BWP": true,
+	"BYN": true, "BZD": true, "CAD": true, "CDF": true, "CHE": true, "CHF": true, "CHW": true, "CLF": true,
+	"CLP": true, "CNY": true, "COP": true, "COU": true, "CRC": true, "CUC": true, "CUP": true, "CVE": true,
+	"CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true, "ERN": true, "ETB": true,
+	"EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true, "GMD": true,
+	"GNF": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true, "HRK": true, "HTG": true, "HUF": true,
+	"IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true, "JMD": true, "JOD": true,
+	"JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true, "KRW": true, "KWD": true,
+	"KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true, "LYD": true,
+	"MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true, "MRU": true,
+	"MUR": true, "MVR": true, "MWK": true, "MXN": true, "MXV": true, "MYR": true, "MZN": true, "NAD": true,
+	"NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true, "OMR": true, "PAB": true, "PEN": true,
+	"PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true, "RSD": true,
+	"RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true,
+	"SHP": true, "SLE": true, "SLL": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true,
+	"SYP": true, "SZL": true, "THB": true, "TJS": true, "TMT": true, "TND": true, "TOP": true, "TRY": true,
+	"TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true, "USD": true, "USN": true, "UYI": true,
+	"UYU": true, "UYW": true, "UZS": true, "VED": true, "VES": true, "VND": true, "VUV": true, "WST": true,
+	"XAF": true, "XAG": true, "XAU": true, "XBA": true, "XBB": true, "XBC": true, "XBD": true, "XCD": true,
+	"XDR": true, "XOF": true, "XPD": true, "XPF": true, "XPT": true, "XSU": true, "XTS": true, "XUA": true,
+	"XXX": true, "YER": true, "ZAR": true, "ZMW": true, "ZWL": true,
+}
+
+type airline struct {
+	Prefix string
+	Code   string
+	Name   string
+}
+
+// airlines maps the IATA air waybill prefixes of cargo carriers to their airline designator.
+var airlines = []airline{
+	{"001", "AA", "American Airlines"},
+	{"006", "DL", "Delta Air Lines"},
+	{"014", "AC", "Air Canada"},
+	{"016", "UA", "United Airlines"},
+	{"020", "LH", "Lufthansa Cargo"},
+	{"023", "FX", "FedEx Express"},
+	{"027", "AS", "Alaska Airlines"},
+	{"045", "LA", "LATAM Airlines"},
+	{"047", "TP", "TAP Air Portugal"},
+	{"055", "AZ", "ITA Airways"},
+	{"057", "AF", "Air France"},
+	{"065", "SV", "Saudia"},
+	{"071", "ET", "Ethiopian Airlines"},
+	{"072", "GF", "Gulf Air"},
+	{"074", "KL", "KLM Royal Dutch Airlines"},
+	{"075", "IB", "Iberia"},
+	{"076", "ME", "Middle East Airlines"},
+	{"077", "MS", "EgyptAir"},
+	{"079", "PR", "Philippine Airlines"},
+	{"080", "LO", "LOT Polish Airlines"},
+	{"081", "QF", "Qantas"},
+	{"082", "SN", "Brussels Airlines"},
+	{"083", "SA", "South African Airways"},
+	{"086", "NZ", "Air New Zealand"},
+	{"098", "AI", "Air India"},
+	{"105", "AY", "Finnair"},
+	{"108", "FI", "Icelandair"},
+	{"112", "CK", "China Cargo Airlines"},
+	{"114", "LY", "El Al"},
+	{"117", "SK", "SAS Scandinavian Airlines"},
+	{"118", "DT", "TAAG Angola Airlines"},
+	{"124", "AH", "Air Algérie"},
+	{"125", "BA", "British Airways"},
+	{"126", "GA", "Garuda Indonesia"},
+	{"131", "JL", "Japan Airlines"},
+	{"139", "AM", "Aeroméxico"},
+	{"141", "FZ", "flydubai"},
+	{"147", "AT", "Royal Air Maroc"},
+	{"157", "QR", "Qatar Airways"},
+	{"160", "CX", "Cathay Pacific"},
+	{"172", "CV", "Cargolux"},
+	{"176", "EK", "Emirates"},
+	{"180", "KE", "Korean Air"},
+	{"205", "NH", "All Nippon Airways"},
+	{"217", "TG", "Thai Airways"},
+	{"229", "KU", "Kuwait Airways"},
+	{"232", "MH", "Malaysia Airlines"},
+	{"235", "TK", "Turkish Airlines"},
+	{"239", "MK", "Air Mauritius"},
+	{"257", "OS", "Austrian Airlines"},
+	{"297", "CI", "China Airlines"},
+	{"369", "5Y", "Atlas Air"},
+	{"403", "PO", "Polar Air Cargo"},
+	{"406", "5X", "UPS Airlines"},
+	{"423", "ER", "DHL Aviation"},
+	{"479", "ZH", "Shenzhen Airlines"},
+	{"526", "WN", "Southwest Airlines"},
+	{"555", "SU", "Aeroflot"},
+	{"566", "PS", "Ukraine International Airlines"},
+	{"607", "EY", "Etihad Airways"},
+	{"615", "QY", "European Air Transport"},
+	{"618", "SQ", "Singapore Airlines"},
+	{"624", "PC", "Pegasus Airlines"},
+	{"695", "BR", "EVA Air"},
+	{"724", "LX", "Swiss International Air Lines"},
+	{"731", "MF", "Xiamen Airlines"},
+	{"738", "VN", "Vietnam Airlines"},
+	{"774", "FM", "Shanghai Airlines"},
+	{"781", "MU", "China Eastern Airlines"},
+	{"784", "CZ", "China Southern Airlines"},
+	{"831", "OU", "Croatia Airlines"},
+	{"876", "3U", "Sichuan Airlines"},
+	{"880", "HU", "Hainan Airlines"},
+	{"910", "WY", "Oman Air"},
+	{"932", "VS", "Virgin Atlantic"},
+	{"988", "OZ", "Asiana Airlines"},
+	{"996", "UX", "Air Europa"},
+	{"999", "CA", "Air China"},
+}
+
+// countryNames lists common names of countries that differ from the ISO 3166-1 names, in the languages manifests
+// are usually written in.
+var countryNames = map[string]string{
+	"deutschland": "DE", "allemagne": "DE", "alemania": "DE", "germania": "DE", "almanya": "DE", "德国": "DE",
+	"usa": "US", "united states of america": "US", "america": "US", "vereinigte staaten": "US", "etats unis": "US",
+	"estados unidos": "US", "abd": "US", "美国": "US",
+	"uk": "GB", "great britain": "GB", "britain": "GB", "england": "GB", "scotland": "GB", "wales": "GB",
+	"northern ireland": "GB", "grossbritannien": "GB", "vereinigtes konigreich": "GB", "royaume uni": "GB",
+	"reino unido": "GB", "ingiltere": "GB", "英国": "GB",
+	"turkey": "TR", "turkei": "TR", "turquie": "TR", "turquia": "TR", "土耳其": "TR",
+	"holland": "NL", "the netherlands": "NL", "niederlande": "NL", "pays bas": "NL", "paises bajos": "NL", "荷兰": "NL",
+	"peoples republic of china": "CN", "prc": "CN", "chine": "CN", "cin": "CN", "中国": "CN",
+	"hongkong": "HK", "hong kong sar": "HK", "hong kong china": "HK", "香港": "HK",
+	"south korea": "KR", "korea": "KR", "republic of korea": "KR", "sudkorea": "KR", "韩国": "KR",
+	"north korea": "KP",
+	"russia":      "RU", "russland": "RU", "russie": "RU", "rusia": "RU", "俄罗斯": "RU",
+	"vietnam": "VN", "taiwan": "TW", "台湾": "TW", "iran": "IR", "bolivia": "BO", "venezuela": "VE", "tanzania": "TZ",
+	"syria": "SY", "laos": "LA", "moldova": "MD", "czech republic": "CZ", "tschechien": "CZ", "macedonia": "MK",
+	"ivory coast": "CI", "japon": "JP", "日本": "JP",
+	"frankreich": "FR", "法国": "FR", "spanien": "ES", "espana": "ES", "espagne": "ES", "西班牙": "ES",
+	"italien": "IT", "italia": "IT", "italie": "IT", "意大利": "IT",
+	"osterreich": "AT", "autriche": "AT", "奥地利": "AT", "schweiz": "CH", "suisse": "CH", "svizzera": "CH", "瑞士": "CH",
+	"polen": "PL", "polska": "PL", "pologne": "PL", "波兰": "PL", "belgien": "BE", "belgique": "BE", "比利时": "BE",
+	"danemark": "DK", "danmark": "DK", "schweden": "SE", "sverige": "SE", "suede": "SE", "瑞典": "SE",
+	"norwegen": "NO", "norge": "NO", "finnland": "FI", "suomi": "FI", "irland": "IE", "ireland": "IE",
+	"griechenland": "GR", "luxemburg": "LU", "ungarn": "HU", "rumanien": "RO", "bulgarien": "BG", "kroatien": "HR",
+	"slowakei": "SK", "slowenien": "SI", "portugal": "PT", "brasil": "BR", "mexiko": "MX",
+}
+
+var countriesByAlpha2, countriesByAlpha3 = indexCountries()
+var countriesByName = indexCountryNames()
+
+func indexCountries() (map[string]*isoCountry, map[string]*isoCountry) {
+	byAlpha2 := make(map[string]*isoCountry, len(isoCountries))
+	byAlpha3 := make(map[string]*isoCountry, len(isoCountries))
+	for i := range isoCountries {
+		byAlpha2[isoCountries[i].Alpha2] = &isoCountries[i]
+		byAlpha3[isoCountries[i].Alpha3] = &isoCountries[i]
+	}
+	return byAlpha2, byAlpha3
+}
+
+func indexCountryNames() map[string]string {
+	byName := make(map[string]string, 2*len(isoCountries)+len(countryNames))
+	ambiguous := make(map[string]bool)
+	for _, country := range isoCountries {
+		byName[countryKey(country.Name)] = country.Alpha2
+		// "Iran, Islamic Republic of" is usually written "Iran", but "Korea" alone is ambiguous
+		if short, _, found := strings.Cut(country.Name, ","); found {
+			key := countryKey(short)
+			if code, exists := byName[key]; exists && code != country.Alpha2 {
+				ambiguous[key] = true
+			}
+			byName[key] = country.Alpha2
+		}
+	}
+	for key := range ambiguous {
+		delete(byName, key)
+	}
+	for name, code := range countryNames {
+		byName[countryKey(name)] = code
+	}
+	return byName
+}
+
+var removeDiacritics = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// countryKey folds a country name to lower case without diacritics and punctuation, so that "Côte d'Ivoire" and
+// "cote divoire" or "U.S.A." and "usa" are the same.
+func countryKey(name string) string {
+	folded, _, err := transform.String(removeDiacritics, name)
+	if err != nil {
+		folded = name
+	}
+	folded = strings.ToLower(strings.ReplaceAll(folded, "ß", "ss"))
+	folded = strings.Map(func(r rune) rune {
+		switch {
+		case r == '.' || r == '\'' || r == '’':
+			return -1
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return r
+		}
+		return ' '
+	}, folded)
+	return strings.Join(strings.Fields(folded), " ")
+}
+
+// normalizeCountry returns the ISO 3166-1 alpha-2 code of a country given by its alpha-2 or alpha-3 code or by its
+// name, e.g. "DE" for "Germany", "Deutschland" or "DEU". ok is false for unknown countries.
+func normalizeCountry(value string) (code string, ok bool) {
+	value = strings.TrimSpace(value)
+	if country := countriesByAlpha2[strings.ToUpper(value)]; country != nil {
+		return country.Alpha2, true
+	}
+	if country := countriesByAlpha3[strings.ToUpper(value)]; country != nil {
+		return country.Alpha2, true
+	}
+	code, ok = countriesByName[countryKey(value)]
+	return code, ok
+}
+
+var airlinesByPrefix = indexAirlines()
+
+func indexAirlines() map[string]*airline {
+	byPrefix := make(map[string]*airline, len(airlines))
+	for i := range airlines {
+		byPrefix[airlines[i].Prefix] = &airlines[i]
+	}
+	return byPrefix
+}
+
+func isISOCountryCode(code string) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return countriesByAlpha2[code] != nil || countriesByAlpha3[code] != nil
+}
+
+func isISOCurrencyCode(code string) bool {
+	return isoCurrencyCodes[strings.ToUpper(strings.TrimSpace(code))]
+}
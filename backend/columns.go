@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type UnresolvedColumn struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// UnresolvedColumnsError lists the mappings that could not be bound to a column of the uploaded manifest.
+type UnresolvedColumnsError struct {
+	Fields []UnresolvedColumn `json:"unresolved"`
+}
+
+func (e *UnresolvedColumnsError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field.Field, field.Reason))
+	}
+	return "unresolved columns: " + strings.Join(messages, "; ")
+}
+
+// bindsColumn reports whether the mapping reads its value from a column.
+func (m *ColumnMapping) bindsColumn() bool {
+	return m.Column != nil || m.Letter != "" || m.Header != "" || m.Expression != ""
+}
+
+// fromDocument reports whether the value comes from the file name, the sheet name or the cells above the data,
+// it is the same for every row.
+func (m *ColumnMapping) fromDocument() bool {
+	return m.UseFilename != nil && *m.UseFilename || m.UseSheetName != nil && *m.UseSheetName || m.Cells != ""
+}
+
+// resolveColumns returns a copy of the schema in which every header or letter binding is replaced by the column
+// index found in the header row and every expression and the template are parsed and bound to the columns and
+// lookup tables. Mappings that are bound to a raw column index are kept as they are.
+func (s *Schema) resolveColumns(headers []string) (*Schema, error) {
+	resolved := *s
+
+	headerIndex := make(map[string]int, len(headers))
+	for i, header := range headers {
+		normalized := normalizeHeader(header)
+		if _, ok := headerIndex[normalized]; !ok && normalized != "" {
+			headerIndex[normalized] = i
+		}
+	}
+
+	unresolvedErr := &UnresolvedColumnsError{}
+	for _, field := range schemaFields {
+		mapping := field.Mapping(&resolved)
+		switch {
+		case mapping.Header != "":
+			column, ok := -1, false
+			for _, name := range append([]string{mapping.Header}, mapping.Aliases...) {
+				if column, ok = headerIndex[normalizeHeader(name)]; ok {
+					break
+				}
+			}
+			if !ok {
+				names := strings.Join(append([]string{mapping.Header}, mapping.Aliases...), `", "`)
+				unresolvedErr.Fields = append(unresolvedErr.Fields, UnresolvedColumn{
+					Field:  field.Key,
+					Reason: fmt.Sprintf(`no column with header "%s"`, names),
+				})
+				continue
+			}
+			mapping.Column = Ptr(column)
+		case mapping.Letter != "":
+			column, err := AlphaToIndex(mapping.Letter)
+			if err != nil {
+				unresolvedErr.Fields = append(unresolvedErr.Fields, UnresolvedColumn{Field: field.Key, Reason: err.Error()})
+				continue
+			}
+			mapping.Column = Ptr(column)
+		case mapping.Expression != "":
+			expression, err := parseExpression(mapping.Expression)
+			if err == nil {
+				err = expression.bind(headerIndex, s.Lookups)
+			}
+			if err != nil {
+				unresolvedErr.Fields = append(unresolvedErr.Fields, UnresolvedColumn{Field: field.Key, Reason: err.Error()})
+				continue
+			}
+			mapping.expression = expression
+		}
+	}
+
+	if s.Template != nil {
+		template, err := compileTemplate(s.Template, headerIndex, s.Lookups)
+		if err != nil {
+			unresolvedErr.Fields = append(unresolvedErr.Fields, UnresolvedColumn{Field: "template", Reason: err.Error()})
+		}
+		resolved.template = template
+	}
+
+	if len(unresolvedErr.Fields) > 0 {
+		return nil, unresolvedErr
+	}
+	return &resolved, nil
+}
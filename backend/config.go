@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultConfigFile       = "config.json"
+	defaultOneRecordServer  = "default"
+	defaultOneRecordTimeout = 30 * time.Second
+)
+
+// Config is read from the JSON file named by CONFIG_FILE (config.json by default). The file is optional,
+// environment variables override the default ONE Record server.
+type Config struct {
+	OneRecord OneRecordConfig `json:"oneRecord"`
+	Mail      *MailConfig     `json:"mail,omitempty"`
+	Folders   *FolderConfig   `json:"folders,omitempty"`
+}
+
+type OneRecordConfig struct {
+	// DefaultServer is used by pipelines that do not select a server. It defaults to "default" or to the only server.
+	DefaultServer string                      `json:"defaultServer,omitempty"`
+	Servers       map[string]*OneRecordServer `json:"servers,omitempty"`
+}
+
+type OneRecordServer struct {
+	// URL is the base URL of the server, logistics objects are created at URL/logistics-objects.
+	URL string `json:"url"`
+	// Token is a static bearer token, OAuth2 is used instead if it is configured.
+	Token  string        `json:"token,omitempty"`
+	OAuth2 *OAuth2Config `json:"oauth2,omitempty"`
+	// Timeout limits each call of the server, including fetching a token, e.g. "10s". It defaults to 30s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// timeout returns the configured timeout, Timeout has been checked by validate.
+func (s *OneRecordServer) timeout() time.Duration {
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		return defaultOneRecordTimeout
+	}
+	return timeout
+}
+
+// OAuth2Config configures the client credentials grant, e.g. against the Keycloak realm of a NE:ONE server:
+// http://localhost:8989/realms/neone/protocol/openid-connect/token
+type OAuth2Config struct {
+	TokenURL     string   `json:"tokenUrl"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// MailConfig enables the SMTP receiver. Mail to <pipeline>@<domain> or to one of the addresses is processed by
+// the pipeline, the attached manifests are uploaded and the sender gets a summary.
+type MailConfig struct {
+	// Listen is the address of the SMTP receiver, e.g. ":2525".
+	Listen string `json:"listen"`
+	Domain string `json:"domain,omitempty"`
+	// Addresses maps further recipient addresses to pipeline names, e.g. "temu-fra@ecom-pipeline.net": "temu".
+	Addresses map[string]string `json:"addresses,omitempty"`
+	// From is the sender of the summaries, it defaults to pipeline@<domain>.
+	From string `json:"from,omitempty"`
+	// Relay sends the summaries. Without relay they are only logged.
+	Relay *SMTPRelay `json:"relay,omitempty"`
+}
+
+type SMTPRelay struct {
+	// Addr is host:port of the relay, it must support STARTTLS unless it is on localhost.
+	Addr     string `json:"addr"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// FolderConfig enables watched folders. Files put into <dir>/<pipeline>/inbox are processed by the pipeline and
+// moved to processed/ or failed/ next to it, together with a <file>.result.json.
+type FolderConfig struct {
+	Dir  string      `json:"dir"`
+	SFTP *SFTPConfig `json:"sftp,omitempty"`
+}
+
+// SFTPConfig enables the embedded SFTP server, users see the folders of the pipelines they may upload to.
+type SFTPConfig struct {
+	// Listen is the address of the SFTP server, e.g. ":2022".
+	Listen string `json:"listen"`
+	// HostKey is the file with the PEM encoded private host key, a key is generated if the file does not exist.
+	HostKey string               `json:"hostKey"`
+	Users   map[string]*SFTPUser `json:"users"`
+}
+
+type SFTPUser struct {
+	Password string `json:"password,omitempty"`
+	// AuthorizedKeys are public keys in the format of authorized_keys files.
+	AuthorizedKeys []string `json:"authorizedKeys,omitempty"`
+	// Pipelines are the pipelines the user may upload to, all pipelines if empty.
+	Pipelines []string `json:"pipelines,omitempty"`
+}
+
+// loadConfig reads the config file and applies the environment variables
+// ONE_RECORD_SERVER_URL, ONE_RECORD_TOKEN, ONE_RECORD_TOKEN_URL, ONE_RECORD_CLIENT_ID, ONE_RECORD_CLIENT_SECRET,
+// ONE_RECORD_SCOPES (space separated) and ONE_RECORD_TIMEOUT to the default server, and MAIL_LISTEN, MAIL_DOMAIN, MAIL_FROM,
+// SMTP_RELAY, SMTP_USERNAME and SMTP_PASSWORD to the mail config, and INBOX_DIR, SFTP_LISTEN, SFTP_HOST_KEY,
+// SFTP_USER and SFTP_PASSWORD to the folder config.
+func loadConfig() (*Config, error) {
+	config := &Config{}
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		path = defaultConfigFile
+	}
+	file, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && os.Getenv("CONFIG_FILE") == "":
+	case err != nil:
+		return nil, err
+	default:
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(config); err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+
+	config.OneRecord.applyEnv()
+	if err := config.OneRecord.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	config.applyMailEnv()
+	if err := config.Mail.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	config.applyFolderEnv()
+	if err := config.Folders.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+func (c *Config) applyMailEnv() {
+	for _, name := range []string{"MAIL_LISTEN", "MAIL_DOMAIN", "MAIL_FROM", "SMTP_RELAY", "SMTP_USERNAME", "SMTP_PASSWORD"} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if c.Mail == nil {
+			c.Mail = &MailConfig{}
+		}
+		if strings.HasPrefix(name, "SMTP_") && c.Mail.Relay == nil {
+			c.Mail.Relay = &SMTPRelay{}
+		}
+		switch name {
+		case "MAIL_LISTEN":
+			c.Mail.Listen = value
+		case "MAIL_DOMAIN":
+			c.Mail.Domain = value
+		case "MAIL_FROM":
+			c.Mail.From = value
+		case "SMTP_RELAY":
+			c.Mail.Relay.Addr = value
+		case "SMTP_USERNAME":
+			c.Mail.Relay.Username = value
+		case "SMTP_PASSWORD":
+			c.Mail.Relay.Password = value
+		}
+	}
+	if c.Mail != nil && c.Mail.From == "" && c.Mail.Domain != "" {
+		c.Mail.From = "pipeline@" + c.Mail.Domain
+	}
+}
+
+func (c *MailConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Listen == "" {
+		return errors.New("mail.listen: must not be empty")
+	}
+	if c.Domain == "" && len(c.Addresses) == 0 {
+		return errors.New("mail.domain: must not be empty without mail.addresses")
+	}
+	for address := range c.Addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("mail.addresses.%s: %w", address, err)
+		}
+	}
+	if c.Relay != nil {
+		if _, _, err := net.SplitHostPort(c.Relay.Addr); err != nil {
+			return fmt.Errorf("mail.relay.addr: %w", err)
+		}
+		if c.From == "" {
+			return errors.New("mail.from: must not be empty with mail.relay")
+		}
+	}
+	return nil
+}
+
+func (c *Config) applyFolderEnv() {
+	if dir, ok := os.LookupEnv("INBOX_DIR"); ok {
+		if c.Folders == nil {
+			c.Folders = &FolderConfig{}
+		}
+		c.Folders.Dir = dir
+	}
+	if c.Folders == nil {
+		return
+	}
+
+	sftp := c.Folders.SFTP
+	for _, name := range []string{"SFTP_LISTEN", "SFTP_HOST_KEY", "SFTP_USER"} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if sftp == nil {
+			sftp = &SFTPConfig{}
+			c.Folders.SFTP = sftp
+		}
+		switch name {
+		case "SFTP_LISTEN":
+			sftp.Listen = value
+		case "SFTP_HOST_KEY":
+			sftp.HostKey = value
+		case "SFTP_USER":
+			if sftp.Users == nil {
+				sftp.Users = make(map[string]*SFTPUser)
+			}
+			sftp.Users[value] = &SFTPUser{Password: os.Getenv("SFTP_PASSWORD")}
+		}
+	}
+	if sftp != nil && sftp.HostKey == "" {
+		sftp.HostKey = "sftp_host_key"
+	}
+}
+
+func (c *FolderConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Dir == "" {
+		return errors.New("folders.dir: must not be empty")
+	}
+	if c.SFTP == nil {
+		return nil
+	}
+	if c.SFTP.Listen == "" {
+		return errors.New("folders.sftp.listen: must not be empty")
+	}
+	if len(c.SFTP.Users) == 0 {
+		return errors.New("folders.sftp.users: must not be empty")
+	}
+	for name, user := range c.SFTP.Users {
+		if user == nil || user.Password == "" && len(user.AuthorizedKeys) == 0 {
+			return fmt.Errorf("folders.sftp.users.%s: needs a password or authorized keys", name)
+		}
+		for i, key := range user.AuthorizedKeys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+				return fmt.Errorf("folders.sftp.users.%s.authorizedKeys[%d]: %w", name, i, err)
+			}
+		}
+		for _, pipeline := range user.Pipelines {
+			if err := validatePipelineName(pipeline); err != nil {
+				return fmt.Errorf("folders.sftp.users.%s.pipelines: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *OneRecordConfig) applyEnv() {
+	if c.DefaultServer == "" {
+		c.DefaultServer = defaultOneRecordServer
+		if len(c.Servers) == 1 {
+			for name := range c.Servers {
+				c.DefaultServer = name
+			}
+		}
+	}
+
+	env := map[string]string{}
+	for _, name := range []string{"ONE_RECORD_SERVER_URL", "ONE_RECORD_TOKEN", "ONE_RECORD_TOKEN_URL", "ONE_RECORD_CLIENT_ID", "ONE_RECORD_CLIENT_SECRET", "ONE_RECORD_SCOPES", "ONE_RECORD_TIMEOUT"} {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+	if len(env) == 0 {
+		return
+	}
+
+	if c.Servers == nil {
+		c.Servers = make(map[string]*OneRecordServer)
+	}
+	server := c.Servers[c.DefaultServer]
+	if server == nil {
+		server = &OneRecordServer{}
+		c.Servers[c.DefaultServer] = server
+	}
+
+	for name, value := range env {
+		switch name {
+		case "ONE_RECORD_SERVER_URL":
+			server.URL = value
+		case "ONE_RECORD_TOKEN":
+			server.Token = value
+		case "ONE_RECORD_TIMEOUT":
+			server.Timeout = value
+		default:
+			if server.OAuth2 == nil {
+				server.OAuth2 = &OAuth2Config{}
+			}
+			switch name {
+			case "ONE_RECORD_TOKEN_URL":
+				server.OAuth2.TokenURL = value
+			case "ONE_RECORD_CLIENT_ID":
+				server.OAuth2.ClientID = value
+			case "ONE_RECORD_CLIENT_SECRET":
+				server.OAuth2.ClientSecret = value
+			case "ONE_RECORD_SCOPES":
+				server.OAuth2.Scopes = strings.Fields(value)
+			}
+		}
+	}
+}
+
+func (c *OneRecordConfig) validate() error {
+	names := make([]string, 0, len(c.Servers))
+	for name := range c.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		server := c.Servers[name]
+		if server == nil {
+			return fmt.Errorf("oneRecord.servers.%s: must not be empty", name)
+		}
+		if err := validateAbsoluteURL(server.URL); err != nil {
+			return fmt.Errorf("oneRecord.servers.%s.url: %w", name, err)
+		}
+		if server.OAuth2 != nil {
+			if err := validateAbsoluteURL(server.OAuth2.TokenURL); err != nil {
+				return fmt.Errorf("oneRecord.servers.%s.oauth2.tokenUrl: %w", name, err)
+			}
+			if server.OAuth2.ClientID == "" {
+				return fmt.Errorf("oneRecord.servers.%s.oauth2.clientId: must not be empty", name)
+			}
+		}
+		if server.Timeout != "" {
+			timeout, err := time.ParseDuration(server.Timeout)
+			if err != nil {
+				return fmt.Errorf("oneRecord.servers.%s.timeout: %w", name, err)
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("oneRecord.servers.%s.timeout: must be positive", name)
+			}
+		}
+	}
+	if len(c.Servers) > 0 && c.Servers[c.DefaultServer] == nil {
+		return fmt.Errorf("oneRecord.defaultServer: unknown server %q", c.DefaultServer)
+	}
+	return nil
+}
+
+func validateAbsoluteURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("%q is not an absolute http(s) URL", value)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Document describes where the rows of a manifest come from. Mappings that are not bound to a column read their
+// value from it, the value is the same for every row.
+type Document struct {
+	Filename string
+	Sheet    string
+	// Preamble holds the rows above the data, see Table.
+	Preamble [][]string
+	// MasterWaybill overrides the master waybill number of the manifest, e.g. from the masterWaybill query parameter.
+	MasterWaybill string
+}
+
+// cells returns the non-empty values of a cell range within the preamble, joined by spaces.
+func (d *Document) cells(cellRange string) string {
+	bounds, err := parseCellRange(cellRange)
+	if err != nil {
+		return ""
+	}
+	var values []string
+	for row := bounds.fromRow; row <= bounds.toRow && row < len(d.Preamble); row++ {
+		columns := d.Preamble[row]
+		for column := bounds.fromColumn; column <= bounds.toColumn && column < len(columns); column++ {
+			if value := strings.TrimSpace(columns[column]); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// cellBounds is a parsed cell range with 0-based, inclusive rows and columns.
+type cellBounds struct {
+	fromRow, toRow       int
+	fromColumn, toColumn int
+}
+
+var cellReferencePattern = regexp.MustCompile(`^([A-Za-z]{1,3})([1-9][0-9]*)$`)
+
+// parseCellRange parses a single cell such as "B2" or a range such as "A1:D3".
+func parseCellRange(cellRange string) (cellBounds, error) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(cellRange), ":")
+	if !isRange {
+		to = from
+	}
+	fromRow, fromColumn, err := parseCellReference(from)
+	if err != nil {
+		return cellBounds{}, err
+	}
+	toRow, toColumn, err := parseCellReference(to)
+	if err != nil {
+		return cellBounds{}, err
+	}
+	return cellBounds{
+		fromRow: min(fromRow, toRow), toRow: max(fromRow, toRow),
+		fromColumn: min(fromColumn, toColumn), toColumn: max(fromColumn, toColumn),
+	}, nil
+}
+
+func parseCellReference(reference string) (row, column int, err error) {
+	match := cellReferencePattern.FindStringSubmatch(strings.TrimSpace(reference))
+	if match == nil {
+		return 0, 0, fmt.Errorf("invalid cell reference %q", reference)
+	}
+	column, err = AlphaToIndex(match[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	row, err = strconv.Atoi(match[2])
+	if err != nil {
+		return 0, 0, err
+	}
+	return row - 1, column, nil
+}
+
+var patterns sync.Map
+
+// compilePattern compiles the pattern of a mapping once, patterns are applied to every row.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := patterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// extractPattern returns the part of value matched by pattern: the group named "value", else the first group,
+// else the whole match. It returns "" if the pattern does not match.
+func extractPattern(pattern *regexp.Regexp, value string) string {
+	match := pattern.FindStringSubmatch(value)
+	if match == nil {
+		return ""
+	}
+	if index := pattern.SubexpIndex("value"); index > 0 {
+		return match[index]
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
+
+// masterWaybillPattern finds an 11 digit air waybill number such as 160-12345675 or 16012345675 in file names,
+// sheet names and banner cells. It is used unless the mapping has its own pattern.
+var masterWaybillPattern = regexp.MustCompile(`(?:^|\D)(\d{3}[-\s]?\d{8})(?:\D|$)`)
+
+var (
+	ErrMissingMasterWaybill      = errors.New("no master waybill number found")
+	ErrConflictingMasterWaybills = errors.New("conflicting master waybill numbers")
+)
+
+// masterWaybillNumber returns the prefix and serial number of the master waybill of a row, or "" if the row has
+// none. Values read from the document are searched for a waybill number unless the mapping has its own pattern.
+func masterWaybillNumber(columns []string, mapping ColumnMapping, doc *Document) (prefix, serial string, err error) {
+	value := cell(columns, mapping, doc)
+	if mapping.fromDocument() && mapping.Pattern == "" {
+		value = extractPattern(masterWaybillPattern, value)
+	}
+	if value == "" {
+		return "", "", nil
+	}
+	return ParseAirWaybillNumber(value)
+}
+
+// masterWaybillReader sets the number of a master waybill from the first row that has one, other rows must not
+// contradict it. A number given with the document overrides the rows.
+type masterWaybillReader struct {
+	master  *Waybill
+	mapping ColumnMapping
+	doc     *Document
+	// row is the row that set the number, 0 if none has
+	row int
+}
+
+func newMasterWaybillReader(master *Waybill, mapping ColumnMapping, doc *Document) (*masterWaybillReader, error) {
+	if doc.MasterWaybill != "" {
+		prefix, serial, err := ParseAirWaybillNumber(doc.MasterWaybill)
+		if err != nil {
+			return nil, err
+		}
+		master.WaybillPrefix, master.WaybillNumber = prefix, serial
+	}
+	return &masterWaybillReader{master: master, mapping: mapping, doc: doc}, nil
+}
+
+func (r *masterWaybillReader) read(columns []string, row int) error {
+	if r.doc.MasterWaybill != "" {
+		return nil
+	}
+	prefix, serial, err := masterWaybillNumber(columns, r.mapping, r.doc)
+	switch {
+	case err != nil:
+		return fmt.Errorf("row %d: %w", row, err)
+	case serial == "":
+	case r.row == 0:
+		r.master.WaybillPrefix, r.master.WaybillNumber = prefix, serial
+		r.row = row
+	case prefix != r.master.WaybillPrefix || serial != r.master.WaybillNumber:
+		return fmt.Errorf("%w: %s in row %d, %s-%s in row %d", ErrConflictingMasterWaybills,
+			r.master.FullWaybillNumber(), r.row, prefix, serial, row)
+	}
+	return nil
+}
+
+// finish makes sure the master waybill has a number and names its carrier, an unknown airline prefix is reported
+// as a warning.
+func (r *masterWaybillReader) finish(report *ValidationReport) error {
+	if r.master.WaybillNumber == "" {
+		return ErrMissingMasterWaybill
+	}
+	if carrier := airlineByPrefix(r.master.WaybillPrefix); carrier != nil {
+		r.master.InvolvedParties = []*Party{newCarrier(carrier)}
+	} else {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("unknown airline prefix %s of master waybill %s",
+			r.master.WaybillPrefix, r.master.FullWaybillNumber()))
+	}
+	return nil
+}
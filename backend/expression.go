@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An expression computes the value of a mapping from the cells of a row, e.g.
+//
+//	concat($M, " ", $N)
+//	split(${Receiver}, ",", 1)
+//	default(lookup(country_map, upper(trim($K))), $K)
+//
+// $M refers to the column with letter M, ${Receiver} to the column with that header. Strings are quoted like Go
+// strings, lookup tables are defined in the lookups of the schema.
+type expression struct {
+	root exprNode
+	// columns and tables are bound by bind, they are collected while parsing.
+	columns []*columnRef
+	tables  []*tableRef
+}
+
+type exprNode interface {
+	eval(columns []string) string
+}
+
+type literal string
+
+func (l literal) eval([]string) string { return string(l) }
+
+type columnRef struct {
+	header string
+	column int
+}
+
+func (c *columnRef) eval(columns []string) string {
+	if c.column < 0 || c.column >= len(columns) {
+		return ""
+	}
+	return strings.TrimSpace(columns[c.column])
+}
+
+type tableRef struct {
+	name    string
+	entries map[string]string
+}
+
+func (t *tableRef) eval([]string) string { return "" }
+
+type call struct {
+	function *exprFunction
+	args     []exprNode
+}
+
+func (c *call) eval(columns []string) string {
+	return c.function.eval(columns, c.args)
+}
+
+type exprFunction struct {
+	minArgs, maxArgs int // maxArgs is -1 for any number of arguments
+	// check is called with the arguments while parsing, it returns why they are invalid or "".
+	check func(args []exprNode) string
+	eval  func(columns []string, args []exprNode) string
+}
+
+var exprFunctions = map[string]*exprFunction{
+	"concat": {minArgs: 1, maxArgs: -1, eval: func(columns []string, args []exprNode) string {
+		var value strings.Builder
+		for _, arg := range args {
+			value.WriteString(arg.eval(columns))
+		}
+		return value.String()
+	}},
+	// split(value, separator, index) returns the part with the 0-based index, negative indexes count from
+	// the end. Parts are trimmed.
+	"split": {minArgs: 3, maxArgs: 3, check: checkSplit, eval: func(columns []string, args []exprNode) string {
+		parts := strings.Split(args[0].eval(columns), args[1].eval(columns))
+		index, _ := strconv.Atoi(args[2].eval(columns))
+		if index < 0 {
+			index += len(parts)
+		}
+		if index < 0 || index >= len(parts) {
+			return ""
+		}
+		return strings.TrimSpace(parts[index])
+	}},
+	"trim": {minArgs: 1, maxArgs: 1, eval: func(columns []string, args []exprNode) string {
+		return strings.TrimSpace(args[0].eval(columns))
+	}},
+	"upper": {minArgs: 1, maxArgs: 1, eval: func(columns []string, args []exprNode) string {
+		return strings.ToUpper(args[0].eval(columns))
+	}},
+	"lower": {minArgs: 1, maxArgs: 1, eval: func(columns []string, args []exprNode) string {
+		return strings.ToLower(args[0].eval(columns))
+	}},
+	// regex(value, pattern) extracts a value like the pattern of a mapping.
+	"regex": {minArgs: 2, maxArgs: 2, check: checkRegex, eval: func(columns []string, args []exprNode) string {
+		pattern, err := compilePattern(args[1].eval(columns))
+		if err != nil {
+			return ""
+		}
+		return extractPattern(pattern, args[0].eval(columns))
+	}},
+	// lookup(table, value) returns the entry of the table for the value or "" if there is none.
+	"lookup": {minArgs: 2, maxArgs: 2, check: checkLookup, eval: func(columns []string, args []exprNode) string {
+		return args[0].(*tableRef).lookup(args[1].eval(columns))
+	}},
+	// default(value, fallback...) returns the first value that is not empty.
+	"default": {minArgs: 2, maxArgs: -1, eval: func(columns []string, args []exprNode) string {
+		for _, arg := range args {
+			if value := arg.eval(columns); value != "" {
+				return value
+			}
+		}
+		return ""
+	}},
+}
+
+func checkSplit(args []exprNode) string {
+	if _, ok := args[1].(literal); !ok {
+		return "separator must be a string"
+	}
+	index, ok := args[2].(literal)
+	if _, err := strconv.Atoi(string(index)); !ok || err != nil {
+		return "index must be a whole number"
+	}
+	return ""
+}
+
+func checkRegex(args []exprNode) string {
+	pattern, ok := args[1].(literal)
+	if !ok {
+		return "pattern must be a string"
+	}
+	if _, err := regexp.Compile(string(pattern)); err != nil {
+		return fmt.Sprintf("invalid pattern: %s", err)
+	}
+	return ""
+}
+
+func checkLookup(args []exprNode) string {
+	if _, ok := args[0].(*tableRef); !ok {
+		return "first argument must be the name of a lookup table"
+	}
+	return ""
+}
+
+// lookup tries the value as it is and then ignoring case and surrounding spaces.
+func (t *tableRef) lookup(value string) string {
+	if entry, ok := t.entries[value]; ok {
+		return entry
+	}
+	for key, entry := range t.entries {
+		if strings.EqualFold(strings.TrimSpace(key), strings.TrimSpace(value)) {
+			return entry
+		}
+	}
+	return ""
+}
+
+// eval returns the value of the expression for a row.
+func (e *expression) eval(columns []string) string {
+	return strings.TrimSpace(e.root.eval(columns))
+}
+
+// bind resolves the header references of the expression with the header row of a manifest and its lookup tables
+// with those of the schema.
+func (e *expression) bind(headerIndex map[string]int, lookups map[string]map[string]string) error {
+	for _, column := range e.columns {
+		if column.header == "" {
+			continue
+		}
+		index, ok := headerIndex[normalizeHeader(column.header)]
+		if !ok {
+			return fmt.Errorf(`no column with header "%s"`, column.header)
+		}
+		column.column = index
+	}
+	for _, table := range e.tables {
+		entries, ok := lookups[table.name]
+		if !ok {
+			return fmt.Errorf("no lookup table %q", table.name)
+		}
+		table.entries = entries
+	}
+	return nil
+}
+
+// usesHeaders reports whether the expression refers to columns by header.
+func (e *expression) usesHeaders() bool {
+	for _, column := range e.columns {
+		if column.header != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpressionError is a syntax error at a byte offset of an expression.
+type ExpressionError struct {
+	Offset  int
+	Message string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("at %d: %s", e.Offset, e.Message)
+}
+
+// parseExpression checks the syntax of an expression, the functions it calls and the number of their arguments.
+func parseExpression(source string) (*expression, error) {
+	p := &exprParser{source: source, expression: &expression{}}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.offset < len(p.source) {
+		return nil, p.errorf("unexpected %q", p.source[p.offset:])
+	}
+	if _, ok := root.(*tableRef); ok {
+		return nil, &ExpressionError{Offset: 0, Message: fmt.Sprintf("%q is not a column, string or function call", source)}
+	}
+	p.expression.root = root
+	return p.expression, nil
+}
+
+type exprParser struct {
+	source     string
+	offset     int
+	expression *expression
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return &ExpressionError{Offset: p.offset, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) skipSpace() {
+	for p.offset < len(p.source) && (p.source[p.offset] == ' ' || p.source[p.offset] == '\t' || p.source[p.offset] == '\n') {
+		p.offset++
+	}
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	p.skipSpace()
+	if p.offset >= len(p.source) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	switch c := p.source[p.offset]; {
+	case c == '$':
+		return p.parseColumn()
+	case c == '"':
+		return p.parseString()
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.offset
+		p.offset++
+		for p.offset < len(p.source) && p.source[p.offset] >= '0' && p.source[p.offset] <= '9' {
+			p.offset++
+		}
+		if p.source[start:p.offset] == "-" {
+			return nil, p.errorf("expected digits after '-'")
+		}
+		return literal(p.source[start:p.offset]), nil
+	case isIdentifierByte(c) && (c < '0' || c > '9'):
+		return p.parseIdentifier()
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *exprParser) parseColumn() (exprNode, error) {
+	p.offset++ // $
+	if strings.HasPrefix(p.source[p.offset:], "{") {
+		end := strings.IndexByte(p.source[p.offset:], '}')
+		if end < 0 {
+			return nil, p.errorf("missing '}'")
+		}
+		header := strings.TrimSpace(p.source[p.offset+1 : p.offset+end])
+		if header == "" {
+			return nil, p.errorf("empty header")
+		}
+		p.offset += end + 1
+		column := &columnRef{header: header, column: -1}
+		p.expression.columns = append(p.expression.columns, column)
+		return column, nil
+	}
+	start := p.offset
+	for p.offset < len(p.source) && (p.source[p.offset] >= 'A' && p.source[p.offset] <= 'Z' || p.source[p.offset] >= 'a' && p.source[p.offset] <= 'z') {
+		p.offset++
+	}
+	index, err := AlphaToIndex(p.source[start:p.offset])
+	if err != nil {
+		p.offset = start
+		return nil, p.errorf("expected a column letter such as $B or a header such as ${Name}")
+	}
+	column := &columnRef{column: index}
+	p.expression.columns = append(p.expression.columns, column)
+	return column, nil
+}
+
+func (p *exprParser) parseString() (exprNode, error) {
+	start := p.offset
+	for p.offset++; p.offset < len(p.source); p.offset++ {
+		switch p.source[p.offset] {
+		case '\\':
+			p.offset++
+		case '"':
+			p.offset++
+			quoted := p.source[start:p.offset]
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				p.offset = start
+				return nil, p.errorf("invalid string %s", quoted)
+			}
+			return literal(value), nil
+		}
+	}
+	p.offset = start
+	return nil, p.errorf("unterminated string")
+}
+
+func (p *exprParser) parseIdentifier() (exprNode, error) {
+	start := p.offset
+	for p.offset < len(p.source) && isIdentifierByte(p.source[p.offset]) {
+		p.offset++
+	}
+	name := p.source[start:p.offset]
+	if p.skipSpace(); p.offset >= len(p.source) || p.source[p.offset] != '(' {
+		table := &tableRef{name: name}
+		p.expression.tables = append(p.expression.tables, table)
+		return table, nil
+	}
+
+	function, ok := exprFunctions[name]
+	if !ok {
+		p.offset = start
+		return nil, p.errorf("unknown function %s", name)
+	}
+	p.offset++ // (
+	var args []exprNode
+	for {
+		if p.skipSpace(); p.offset < len(p.source) && p.source[p.offset] == ')' && len(args) == 0 {
+			p.offset++
+			break
+		}
+		arg, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if table, ok := arg.(*tableRef); ok && (name != "lookup" || len(args) > 0) {
+			return nil, p.errorf("%s is not a column, string or function call", table.name)
+		}
+		args = append(args, arg)
+
+		p.skipSpace()
+		if p.offset >= len(p.source) {
+			return nil, p.errorf("missing ')'")
+		}
+		if p.source[p.offset] == ')' {
+			p.offset++
+			break
+		}
+		if p.source[p.offset] != ',' {
+			return nil, p.errorf("expected ',' or ')'")
+		}
+		p.offset++
+	}
+
+	switch {
+	case len(args) < function.minArgs:
+		return nil, &ExpressionError{Offset: start, Message: fmt.Sprintf("%s needs at least %s", name, countArguments(function.minArgs))}
+	case function.maxArgs >= 0 && len(args) > function.maxArgs:
+		return nil, &ExpressionError{Offset: start, Message: fmt.Sprintf("%s takes at most %s", name, countArguments(function.maxArgs))}
+	}
+	if function.check != nil {
+		if reason := function.check(args); reason != "" {
+			return nil, &ExpressionError{Offset: start, Message: fmt.Sprintf("%s: %s", name, reason)}
+		}
+	}
+	return &call{function: function, args: args}, nil
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func countArguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{"column", "$M", ""},
+		{"header", "${Receiver Name}", ""},
+		{"string", `"DE"`, ""},
+		{"nested calls", `default(lookup(countries, upper(trim($K))), $K)`, ""},
+		{"spaces", " concat( $A ,\t\"-\" , $B ) ", ""},
+		{"no arguments", "concat()", "at 0: concat needs at least 1 argument"},
+		{"too many arguments", "trim($A, $B)", "at 0: trim takes at most 1 argument"},
+		{"too few arguments", `split($A, ",")`, "at 0: split needs at least 3 arguments"},
+		{"unknown function", "upper(reverse($A))", "at 6: unknown function reverse"},
+		{"missing parenthesis", "upper($A", "at 8: missing ')'"},
+		{"missing comma", "concat($A $B)", "at 10: expected ',' or ')'"},
+		{"trailing input", "$A $B", `at 3: unexpected "$B"`},
+		{"empty", "", "at 0: unexpected end of expression"},
+		{"invalid column", "$1", "at 1: expected a column letter such as $B or a header such as ${Name}"},
+		{"missing brace", "${Name", "at 1: missing '}'"},
+		{"empty header", "${ }", "at 1: empty header"},
+		{"unterminated string", `concat($A, "x)`, "at 11: unterminated string"},
+		{"invalid escape", `"\q"`, `at 0: invalid string "\q"`},
+		{"table outside lookup", "upper(countries)", "at 15: countries is not a column, string or function call"},
+		{"only a table", "countries", `at 0: "countries" is not a column, string or function call`},
+		{"lookup without table", `lookup("countries", $A)`, "at 0: lookup: first argument must be the name of a lookup table"},
+		{"split separator from column", "split($A, $B, 0)", "at 0: split: separator must be a string"},
+		{"split index not a number", `split($A, ",", "first")`, "at 0: split: index must be a whole number"},
+		{"invalid regex", `regex($A, "(")`, "at 0: regex: invalid pattern: error parsing regexp: missing closing ): `(`"},
+		{"lone minus", `split($A, ",", -)`, "at 16: expected digits after '-'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseExpression(test.source)
+			if got := errorString(err); got != test.err {
+				t.Errorf("parseExpression(%q) error = %q, want %q", test.source, got, test.err)
+			}
+			var exprErr *ExpressionError
+			if err != nil && !errors.As(err, &exprErr) {
+				t.Errorf("got %T, want *ExpressionError", err)
+			}
+		})
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	headerIndex := map[string]int{"RECEIVER": 1, "COUNTRY": 2, "RECEIVER NAME": 3}
+	lookups := map[string]map[string]string{"countries": {"Deutschland": "DE", "China": "CN"}}
+
+	tests := []struct {
+		name    string
+		source  string
+		columns []string
+		want    string
+	}{
+		{"column letter", "$B", []string{"T1", "Max Muster"}, "Max Muster"},
+		{"header", "${Receiver}", []string{"T1", "Max Muster"}, "Max Muster"},
+		{"header with other punctuation", "${receiver-name}", []string{"T1", "", "", "Erika"}, "Erika"},
+		{"letter and header", `concat($A, "/", ${Country})`, []string{"T1", "", "DE"}, "T1/DE"},
+		{"missing column", "$Z", []string{"T1"}, ""},
+		{"cells are trimmed", `concat("[", $A, "]")`, []string{" T1 "}, "[T1]"},
+		{"result is trimmed", `concat(" ", $A, " ")`, []string{"T1"}, "T1"},
+		{"escapes", `concat($A, "\t\"x\"ä")`, []string{"T1"}, "T1\t\"x\"ä"},
+		{"concat", `concat($A, " ", $B)`, []string{"Max", "Muster"}, "Max Muster"},
+		{"split", `split($A, ",", 1)`, []string{"Hauptstr. 1, 10115 Berlin"}, "10115 Berlin"},
+		{"split from the end", `split($A, " ", -1)`, []string{"10115 Berlin Mitte"}, "Mitte"},
+		{"split out of range", `split($A, ",", 3)`, []string{"a,b"}, ""},
+		{"trim", `concat("[", trim(" x "), "]")`, nil, "[x]"},
+		{"upper", "upper($A)", []string{"de"}, "DE"},
+		{"lower", "lower($A)", []string{"Max@Example.com"}, "max@example.com"},
+		{"regex", `regex($A, "\\d{5}")`, []string{"Berlin 10115"}, "10115"},
+		{"regex group", `regex($A, "Tel: (\\+?\\d+)")`, []string{"Tel: +4930123"}, "+4930123"},
+		{"regex without match", `regex($A, "\\d{5}")`, []string{"Berlin"}, ""},
+		{"lookup", "lookup(countries, $A)", []string{"Deutschland"}, "DE"},
+		{"lookup ignores case and spaces", "lookup(countries, $A)", []string{" china"}, "CN"},
+		{"lookup without entry", "lookup(countries, $A)", []string{"France"}, ""},
+		{"default", "default(lookup(countries, $A), $A)", []string{"FR"}, "FR"},
+		{"default of empty cells", `default($A, $B, "unknown")`, []string{"", " "}, "unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := parseExpression(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := expression.bind(headerIndex, lookups); err != nil {
+				t.Fatal(err)
+			}
+			if got := expression.eval(test.columns); got != test.want {
+				t.Errorf("%s = %q, want %q", test.source, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpressionBind(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		headerIndex map[string]int
+		lookups     map[string]map[string]string
+		err         string
+	}{
+		{"letters only", "$A", nil, nil, ""},
+		{"header", "${Country}", map[string]int{"COUNTRY": 0}, nil, ""},
+		{"missing header", "${Country}", map[string]int{"NAME": 0}, nil, `no column with header "Country"`},
+		{"lookup table", "lookup(countries, $A)", nil, map[string]map[string]string{"countries": {}}, ""},
+		{"missing lookup table", "lookup(countries, $A)", nil, map[string]map[string]string{"codes": {}}, `no lookup table "countries"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := parseExpression(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := errorString(expression.bind(test.headerIndex, test.lookups)); got != test.err {
+				t.Errorf("got error %q, want %q", got, test.err)
+			}
+		})
+	}
+}
+
+// TestSavePipelineExpression checks that invalid expressions are reported when the pipeline is saved, not when
+// the first manifest is processed.
+func TestSavePipelineExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		noHeader   bool
+		errors     []FieldError
+	}{
+		{"valid", `concat(lookup(countries, $A), " ", ${Name})`, false, nil},
+		{"syntax error", "upper($B", false, []FieldError{{"recipientName", "invalid expression: at 8: missing ')'"}}},
+		{"unknown function", "reverse($B)", false, []FieldError{{"recipientName", "invalid expression: at 0: unknown function reverse"}}},
+		{"wrong number of arguments", "upper($B, $C)", false, []FieldError{{"recipientName", "invalid expression: at 0: upper takes at most 1 argument"}}},
+		{"missing lookup table", "lookup(codes, $B)", false, []FieldError{{"recipientName", `invalid expression: no lookup table "codes"`}}},
+		{"header without header row", "upper(${Name})", true, []FieldError{{"recipientName", "header reference in expression requires a header row"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := NewFilePipelineStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pipeline := &Pipeline{Name: "temu", Mapping: &Schema{
+				MasterWaybillNumber: ColumnMapping{Letter: "A"},
+				RecipientName:       ColumnMapping{Expression: test.expression},
+				Lookups:             map[string]map[string]string{"countries": {"Deutschland": "DE"}},
+				Template:            map[string]any{"waybillNumber": "$B"},
+			}}
+			if test.noHeader {
+				pipeline.Mapping.Layout = &SheetLayout{NoHeader: true}
+			}
+
+			_, err = savePipeline(store, pipeline, PipelineActionCreate)
+			var validationErr *ValidationError
+			switch {
+			case test.errors == nil && err != nil:
+				t.Fatal(err)
+			case test.errors != nil && !errors.As(err, &validationErr):
+				t.Fatalf("got error %v, want a validation error", err)
+			case test.errors != nil && !reflect.DeepEqual(validationErr.Errors, test.errors):
+				t.Errorf("got errors %v, want %v", validationErr.Errors, test.errors)
+			}
+		})
+	}
+}
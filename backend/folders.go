@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	folderInbox     = "inbox"
+	folderProcessed = "processed"
+	folderFailed    = "failed"
+
+	folderPollInterval = 5 * time.Second
+	// uploadSuffix marks files that are still being written by the SFTP server.
+	uploadSuffix = ".part"
+	resultSuffix = ".result.json"
+)
+
+// partialSuffixes are used by the SFTP server and by clients such as WinSCP for files that are still being written.
+var partialSuffixes = []string{uploadSuffix, ".filepart", ".tmp"}
+
+func isPartialFile(name string) bool {
+	for _, suffix := range partialSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// FolderResult is written next to a processed or failed file.
+type FolderResult struct {
+	File        string    `json:"file"`
+	Pipeline    string    `json:"pipeline"`
+	ProcessedAt time.Time `json:"processedAt"`
+	Job         *Job      `json:"job,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// FolderWatcher processes the files in the inbox folders of all pipelines.
+type FolderWatcher struct {
+	dir      string
+	store    PipelineStore
+	ingester *Ingester
+
+	mu sync.Mutex
+	// sizes remembers the size and modification time of files at the last poll, a file is processed only once
+	// both stopped changing, so that files that are still being copied are not read.
+	sizes map[string]fileStamp
+	busy  map[string]bool
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func NewFolderWatcher(dir string, store PipelineStore, ingester *Ingester) *FolderWatcher {
+	return &FolderWatcher{dir: dir, store: store, ingester: ingester, sizes: make(map[string]fileStamp), busy: make(map[string]bool)}
+}
+
+// pipelineDir returns the folder of a pipeline, or one of its inbox, processed and failed folders.
+func (f *FolderWatcher) pipelineDir(pipeline string, folder ...string) string {
+	return filepath.Join(append([]string{f.dir, pipeline}, folder...)...)
+}
+
+// Run polls the inbox folders until ctx is done.
+func (f *FolderWatcher) Run(ctx context.Context) {
+	log.Info().Str("dir", f.dir).Msg("watching folders")
+	ticker := time.NewTicker(folderPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := f.poll(); err != nil {
+			log.Err(err).Str("dir", f.dir).Msg("poll folders")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *FolderWatcher) poll() error {
+	pipelines, err := f.store.List()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, pipeline := range pipelines {
+		// new pipelines get their folders on the next poll
+		for _, folder := range []string{folderInbox, folderProcessed, folderFailed} {
+			if err := os.MkdirAll(f.pipelineDir(pipeline.Name, folder), 0755); err != nil {
+				return err
+			}
+		}
+
+		inbox := f.pipelineDir(pipeline.Name, folderInbox)
+		entries, err := os.ReadDir(inbox)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || isPartialFile(name) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(inbox, name)
+			seen[path] = true
+			if f.ready(path, fileStamp{size: info.Size(), modTime: info.ModTime()}) {
+				go f.process(pipeline.Name, name)
+			}
+		}
+	}
+
+	f.mu.Lock()
+	for path := range f.sizes {
+		if !seen[path] {
+			delete(f.sizes, path)
+		}
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+// ready tells whether a file stopped changing since the last poll and is not processed yet. It marks the file busy.
+func (f *FolderWatcher) ready(path string, stamp fileStamp) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous, ok := f.sizes[path]
+	f.sizes[path] = stamp
+	if !ok || previous != stamp || f.busy[path] {
+		return false
+	}
+	f.busy[path] = true
+	return true
+}
+
+func (f *FolderWatcher) process(pipelineName, name string) {
+	path := f.pipelineDir(pipelineName, folderInbox, name)
+	fail := func(err error) {
+		f.finish(pipelineName, name, nil, err)
+	}
+
+	pipeline, err := f.store.Get(pipelineName)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fail(err)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	file.Close()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	source, err := openRowSource(bytes.NewReader(data), "", name, pipeline.Mapping.Layout)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if _, err := f.ingester.Submit(pipeline, source, name, SubmitOptions{}, func(job *Job) {
+		f.finish(pipelineName, name, job, nil)
+	}); err != nil {
+		if errors.Is(err, ErrJobQueueFull) {
+			// leave the file in the inbox, it is tried again on one of the next polls
+			log.Warn().Str("pipeline", pipelineName).Str("file", name).Msg("job queue is full")
+			f.release(path)
+			return
+		}
+		fail(err)
+	}
+}
+
+// finish moves a file out of the inbox and writes the result next to it.
+func (f *FolderWatcher) finish(pipelineName, name string, job *Job, err error) {
+	path := f.pipelineDir(pipelineName, folderInbox, name)
+	defer f.release(path)
+
+	result := &FolderResult{File: name, Pipeline: pipelineName, ProcessedAt: time.Now().UTC(), Job: job}
+	folder := folderProcessed
+	if err != nil {
+		result.Error = err.Error()
+		folder = folderFailed
+	} else if job.Status != JobSucceeded {
+		result.Error = job.Error
+		folder = folderFailed
+	}
+
+	// files with the same name are dropped again and again, the time keeps them apart
+	target := f.pipelineDir(pipelineName, folder, result.ProcessedAt.Format("20060102T150405.000Z")+"-"+name)
+	logger := log.With().Str("pipeline", pipelineName).Str("file", name).Str("folder", folder).Logger()
+	if err := os.Rename(path, target); err != nil {
+		logger.Err(err).Msg("move processed file")
+		return
+	}
+
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err == nil {
+		err = os.WriteFile(target+resultSuffix, body, 0644)
+	}
+	if err != nil {
+		logger.Err(err).Msg("write result")
+	}
+	logger.Info().Str("error", result.Error).Msg("processed file")
+}
+
+func (f *FolderWatcher) release(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.busy, path)
+	delete(f.sizes, path)
+}
+
+// inboxFile returns the path of a file dropped for a pipeline, it is used by the SFTP server.
+func (f *FolderWatcher) inboxFile(pipeline, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return f.pipelineDir(pipeline, folderInbox, name), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type valueKind int
+
+const (
+	valueKindNone valueKind = iota
+	valueKindAWB
+	valueKindHSCode
+	valueKindCountry
+	valueKindCurrency
+	valueKindWeight
+	valueKindPostcode
+	valueKindQuantity
+	valueKindPrice
+	valueKindEmail
+	valueKindPhone
+	valueKindEORI
+	valueKindVAT
+	valueKindIOSS
+)
+
+// specificity tells how unlikely it is that values of another kind are mistaken for this kind.
+func (k valueKind) specificity() float64 {
+	switch k {
+	case valueKindAWB, valueKindCountry, valueKindCurrency, valueKindEmail, valueKindIOSS:
+		return 1
+	case valueKindHSCode:
+		return 0.8
+	case valueKindWeight, valueKindPostcode, valueKindEORI, valueKindVAT:
+		return 0.6
+	case valueKindQuantity, valueKindPrice, valueKindPhone:
+		return 0.4
+	}
+	return 0
+}
+
+var (
+	awbPattern      = regexp.MustCompile(`^\d{3}[- ]?\d{4} ?\d{4}$`)
+	hsCodePattern   = regexp.MustCompile(`^\d{4}(\.?\d{2}){1,3}$`)
+	weightPattern   = regexp.MustCompile(`(?i)^\d+([.,]\d+)?\s*(kg|kgs|kgm|g|gr|grm|lb|lbs|lbr)$`)
+	decimalPattern  = regexp.MustCompile(`^\d+([.,]\d+)?$`)
+	postcodePattern = regexp.MustCompile(`(?i)^([0-9]{4,6}|[0-9]{3}-[0-9]{4}|[0-9]{5}-[0-9]{4}|[A-Z]{1,2}[0-9][A-Z0-9]? ?[0-9][A-Z]{2}|[A-Z][0-9][A-Z] ?[0-9][A-Z][0-9])$`)
+)
+
+func (k valueKind) matches(value string) bool {
+	value = strings.TrimSpace(value)
+	switch k {
+	case valueKindAWB:
+		return awbPattern.MatchString(value)
+	case valueKindHSCode:
+		return hsCodePattern.MatchString(value)
+	case valueKindCountry:
+		if len(value) <= 3 {
+			return strings.ToUpper(value) == value && isISOCountryCode(value)
+		}
+		_, ok := normalizeCountry(value)
+		return ok
+	case valueKindCurrency:
+		return len(value) == 3 && isISOCurrencyCode(value)
+	case valueKindWeight:
+		if weightPattern.MatchString(value) {
+			return true
+		}
+		weight, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+		return err == nil && decimalPattern.MatchString(value) && weight > 0 && weight < 1000
+	case valueKindPostcode:
+		return postcodePattern.MatchString(value)
+	case valueKindQuantity:
+		quantity, err := strconv.Atoi(value)
+		return err == nil && quantity > 0 && quantity < 1000
+	case valueKindPrice:
+		return decimalPattern.MatchString(value)
+	case valueKindEmail:
+		return emailPattern.MatchString(value)
+	case valueKindPhone:
+		return phonePattern.MatchString(value) && countDigits(value) >= 5
+	case valueKindEORI:
+		return eoriPattern.MatchString(normalizeTaxIdentifier(value))
+	case valueKindVAT:
+		return vatPattern.MatchString(normalizeTaxIdentifier(value))
+	case valueKindIOSS:
+		return iossPattern.MatchString(normalizeTaxIdentifier(value))
+	}
+	return false
+}
+
+// contentScore returns the share of non-empty sample values in a column that look like the given kind.
+func contentScore(kind valueKind, samples [][]string, column int) float64 {
+	if kind == valueKindNone {
+		return 0
+	}
+	total, matching := 0, 0
+	for _, row := range samples {
+		if column >= len(row) || strings.TrimSpace(row[column]) == "" {
+			continue
+		}
+		total++
+		if kind.matches(row[column]) {
+			matching++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(matching) / float64(total)
+}
+
+// combineScores merges the header similarity with what the sampled values of a column look like.
+func combineScores(headerScore, contentScore float64, kind valueKind, hasSamples bool) float64 {
+	if kind == valueKindNone || !hasSamples {
+		return headerScore
+	}
+	switch {
+	case headerScore >= minSuggestionConfidence && contentScore >= 0.8:
+		return min(1, headerScore+0.05)
+	case headerScore >= minSuggestionConfidence && contentScore < 0.2:
+		// the header looks right, but the values do not
+		return headerScore * 0.85
+	case headerScore >= minSuggestionConfidence:
+		return headerScore
+	}
+
+	contentOnly := 0.55 + 0.35*contentScore*kind.specificity()
+	if contentScore < 0.8 || contentOnly < minSuggestionConfidence {
+		return headerScore
+	}
+	return max(headerScore, contentOnly)
+}
+
+func columnSamples(samples [][]string, column int) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, row := range samples {
+		if len(values) == maxSuggestionSamples {
+			break
+		}
+		if column >= len(row) {
+			continue
+		}
+		value := strings.TrimSpace(row[column])
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrInvalidManifest is returned when the header of a manifest cannot be read or does not match the pipeline.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// Ingester turns manifests into jobs that transform and publish them. It is shared by all ingestion channels.
+type Ingester struct {
+	oneRecord    *OneRecordClients
+	publications *PublicationStore
+	outbox       *Outbox
+	jobs         *JobQueue
+}
+
+// SubmitOptions are set per upload.
+type SubmitOptions struct {
+	// DryRun transforms and validates the manifest without publishing it.
+	DryRun bool
+	// MasterWaybill overrides the master waybill number mapped by the pipeline.
+	MasterWaybill string
+}
+
+// Submit checks the header of the manifest and queues a job for its rows. The source is closed when the job is done,
+// or right away on error. onDone, if not nil, is called with the finished job.
+func (in *Ingester) Submit(pipeline *Pipeline, source RowSource, filename string, options SubmitOptions, onDone func(job *Job)) (*Job, error) {
+	rows, err := openTable(source, pipeline.Mapping.Layout)
+	if err != nil {
+		source.Close()
+		return nil, fmt.Errorf("%w: read header: %w", ErrInvalidManifest, err)
+	}
+
+	schema, err := pipeline.Mapping.resolveColumns(rows.Headers)
+	if err != nil {
+		source.Close()
+		return nil, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+
+	doc := &Document{
+		Filename:      filename,
+		Sheet:         sheetName(source),
+		Preamble:      rows.Preamble,
+		MasterWaybill: options.MasterWaybill,
+	}
+	job, err := in.jobs.Submit(pipeline, filename, options.DryRun, func(progress *JobProgress) (any, error) {
+		defer source.Close()
+		return in.process(pipeline, schema, rows, doc, options.DryRun, progress)
+	}, onDone)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	return job, nil
+}
+
+// convertedWaybill is a master waybill converted from the rows of a manifest, by the fields of the schema or by
+// its template.
+type convertedWaybill interface {
+	FullWaybillNumber() string
+	// objects splits the waybill into logistics objects, every call returns new objects without URIs.
+	objects() (*PublishedObject, error)
+	dryRun(report *ValidationReport) (DryRunResult, error)
+}
+
+func convertManifest(schema *Schema, rows *Table, doc *Document, progress *JobProgress) (convertedWaybill, *ValidationReport, error) {
+	if schema.template != nil {
+		waybill, report, err := templateToOneRecord(schema, rows, doc, progress)
+		if err != nil {
+			return nil, report, err
+		}
+		return waybill, report, nil
+	}
+	waybill, report, err := excelToOneRecord(schema, rows, doc, progress)
+	if err != nil {
+		return nil, report, err
+	}
+	return waybill, report, nil
+}
+
+func (in *Ingester) process(pipeline *Pipeline, schema *Schema, rows *Table, doc *Document, dryRun bool, progress *JobProgress) (any, error) {
+	waybill, report, err := convertManifest(schema, rows, doc, progress)
+	if err != nil {
+		if errors.As(err, &report) {
+			return report, err
+		}
+		return nil, err
+	}
+
+	if dryRun {
+		return waybill.dryRun(report)
+	}
+
+	client, err := in.oneRecord.Client(pipeline.Server)
+	if err != nil {
+		return nil, fmt.Errorf("select ONE Record server %q: %w", pipeline.Server, err)
+	}
+
+	masterWaybillNumber := waybill.FullWaybillNumber()
+	root, err := waybill.objects()
+	if err != nil {
+		return nil, fmt.Errorf("prepare logistics objects: %w", err)
+	}
+
+	// older submissions in the outbox must not be retried over this upload, a retry that already waits for the
+	// publication checks the status once it holds the lock
+	in.outbox.Supersede(client.Name, masterWaybillNumber)
+
+	published, err := publishWaybill(client, in.publications, masterWaybillNumber, root, progress)
+	if err != nil {
+		log.Err(err).Str("waybill", masterWaybillNumber).Msg("publish waybill")
+
+		// the objects of the failed attempt carry URIs, the outbox starts from fresh ones
+		root, rootErr := waybill.objects()
+		if rootErr != nil {
+			return nil, fmt.Errorf("prepare logistics objects: %w", rootErr)
+		}
+		entry, enqueueErr := in.outbox.Enqueue(pipeline.Name, client.Name, masterWaybillNumber, root, err)
+		if enqueueErr != nil {
+			return nil, fmt.Errorf("enqueue submission: %w", enqueueErr)
+		}
+		entry.Objects = nil
+		return &InputResult{
+			HouseWaybills: map[HouseWaybillNumber]string{},
+			Report:        report,
+			Outbox:        entry,
+			Error:         err.Error(),
+		}, err
+	}
+
+	return &InputResult{
+		LogisticsObjectURL: published.MasterWaybill,
+		HouseWaybills:      published.HouseWaybills,
+		Created:            published.Created,
+		Changed:            published.Changed,
+		Report:             report,
+	}, nil
+}
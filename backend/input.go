@@ -0,0 +1,687 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/extrame/xls"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const maxUploadSize = 100 << 20
+
+var ErrUnsupportedFormat = errors.New("unsupported input format")
+
+// RowSource iterates over the rows of an uploaded manifest, independent of its file format. Like bufio.Scanner,
+// Next returns false at the end of the input or when it cannot be read; Err tells the two apart.
+type RowSource interface {
+	Next() bool
+	Columns() ([]string, error)
+	Err() error
+	Close() error
+}
+
+// sheetNamer is implemented by the row sources of workbooks.
+type sheetNamer interface {
+	SheetName() string
+}
+
+// sheetName returns the name of the sheet the rows are read from, it is empty for CSV and JSON.
+func sheetName(source RowSource) string {
+	if namer, ok := source.(sheetNamer); ok {
+		return namer.SheetName()
+	}
+	return ""
+}
+
+type InputFormat string
+
+const (
+	InputFormatXLSX InputFormat = "xlsx"
+	InputFormatXLS  InputFormat = "xls"
+	InputFormatODS  InputFormat = "ods"
+	InputFormatCSV  InputFormat = "csv"
+	InputFormatJSON InputFormat = "json"
+)
+
+var contentTypeFormats = map[string]InputFormat{
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": InputFormatXLSX,
+	"application/vnd.ms-excel":                       InputFormatXLS,
+	"application/vnd.oasis.opendocument.spreadsheet": InputFormatODS,
+	"text/csv":                  InputFormatCSV,
+	"application/csv":           InputFormatCSV,
+	"text/tab-separated-values": InputFormatCSV,
+	"text/plain":                InputFormatCSV,
+	"application/json":          InputFormatJSON,
+	"text/json":                 InputFormatJSON,
+}
+
+var extensionFormats = map[string]InputFormat{
+	".xlsx": InputFormatXLSX,
+	".xlsm": InputFormatXLSX,
+	".xls":  InputFormatXLS,
+	".ods":  InputFormatODS,
+	".csv":  InputFormatCSV,
+	".tsv":  InputFormatCSV,
+	".txt":  InputFormatCSV,
+	".json": InputFormatJSON,
+}
+
+// openRequestRowSource reads the manifest from the request body or, for multipart forms, from the first file part.
+// It also returns the name of the uploaded file.
+func openRequestRowSource(r *http.Request, layout *SheetLayout) (RowSource, string, error) {
+	contentType := r.Header.Get("Content-Type")
+	filename := dispositionFilename(r.Header.Get("Content-Disposition"))
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType != "multipart/form-data" {
+		source, err := openRowSource(r.Body, contentType, filename, layout)
+		return source, filename, err
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, "", fmt.Errorf("read multipart form: %w", err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		source, err := openRowSource(part, part.Header.Get("Content-Type"), part.FileName(), layout)
+		return source, part.FileName(), err
+	}
+}
+
+// dispositionFilename reads the file name of a Content-Disposition header such as
+// `attachment; filename="160-12345675.xlsx"` or `attachment; filename*=UTF-8'en'manifest.xlsx`. A header without parameters
+// is taken as the file name itself, older clients send just the name. Directories are removed.
+func dispositionFilename(header string) string {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return ""
+	}
+	filename := header
+	if _, params, err := mime.ParseMediaType(header); err == nil {
+		filename = params["filename"]
+	} else if strings.Contains(header, "=") {
+		// a malformed header, try the plain filename parameter
+		_, value, _ := strings.Cut(header, "filename=")
+		value, _, _ = strings.Cut(value, ";")
+		filename = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	// browsers on Windows used to send the full path
+	filename = filename[strings.LastIndexAny(filename, `/\`)+1:]
+	return filename
+}
+
+// openRowSource detects the format of the input from the content type, the file name or the content itself
+// and returns the rows of the sheet selected by the layout.
+func openRowSource(r io.Reader, contentType, filename string, layout *SheetLayout) (RowSource, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUploadSize {
+		return nil, fmt.Errorf("input is larger than %d bytes", maxUploadSize)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	format, ok := contentTypeFormats[mediaType]
+	if !ok {
+		format, ok = extensionFormats[strings.ToLower(filepath.Ext(filename))]
+	}
+	if !ok {
+		format = sniffFormat(data)
+	}
+
+	switch format {
+	case InputFormatXLSX:
+		return openXLSX(data, layout)
+	case InputFormatXLS:
+		return openXLS(data, layout)
+	case InputFormatODS:
+		return openODS(data, layout)
+	case InputFormatCSV:
+		return openCSV(data, params["charset"])
+	case InputFormatJSON:
+		return openJSON(data)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+var (
+	zipMagic = []byte("PK\x03\x04")
+	oleMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+)
+
+func sniffFormat(data []byte) InputFormat {
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		// ODS files start with an uncompressed mimetype entry
+		if bytes.Contains(data[:min(len(data), 128)], []byte("application/vnd.oasis.opendocument.spreadsheet")) {
+			return InputFormatODS
+		}
+		return InputFormatXLSX
+	case bytes.HasPrefix(data, oleMagic):
+		return InputFormatXLS
+	}
+
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return InputFormatJSON
+	}
+	return InputFormatCSV
+}
+
+// sliceRowSource serves rows that have already been read into memory.
+type sliceRowSource struct {
+	rows    [][]string
+	current int
+	sheet   string
+}
+
+func newSliceRowSource(rows [][]string) *sliceRowSource {
+	return &sliceRowSource{rows: rows, current: -1}
+}
+
+func (s *sliceRowSource) Next() bool {
+	s.current++
+	return s.current < len(s.rows)
+}
+
+func (s *sliceRowSource) Columns() ([]string, error) {
+	if s.current < 0 || s.current >= len(s.rows) {
+		return nil, io.EOF
+	}
+	return s.rows[s.current], nil
+}
+
+func (s *sliceRowSource) Err() error {
+	return nil
+}
+
+func (s *sliceRowSource) Close() error {
+	return nil
+}
+
+func (s *sliceRowSource) SheetName() string {
+	return s.sheet
+}
+
+type xlsxRowSource struct {
+	file  *excelize.File
+	rows  *excelize.Rows
+	sheet string
+}
+
+func openXLSX(data []byte, layout *SheetLayout) (RowSource, error) {
+	file, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	sheetList := file.GetSheetList()
+	sheet, err := layout.selectSheet(sheetList)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	rows, err := file.Rows(sheetList[sheet])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &xlsxRowSource{file: file, rows: rows, sheet: sheetList[sheet]}, nil
+}
+
+func (s *xlsxRowSource) Next() bool {
+	return s.rows.Next()
+}
+
+func (s *xlsxRowSource) Columns() ([]string, error) {
+	return s.rows.Columns()
+}
+
+func (s *xlsxRowSource) Err() error {
+	return s.rows.Error()
+}
+
+func (s *xlsxRowSource) Close() error {
+	return errors.Join(s.rows.Close(), s.file.Close())
+}
+
+func (s *xlsxRowSource) SheetName() string {
+	return s.sheet
+}
+
+func openXLS(data []byte, layout *SheetLayout) (source RowSource, err error) {
+	// the xls package panics on malformed files and missing rows
+	defer func() {
+		if r := recover(); r != nil {
+			source, err = nil, fmt.Errorf("read xls: %v", r)
+		}
+	}()
+
+	workbook, err := xls.OpenReader(bytes.NewReader(data), "utf-8")
+	if err != nil {
+		return nil, err
+	}
+	sheetList := make([]string, workbook.NumSheets())
+	for i := range sheetList {
+		sheetList[i] = workbook.GetSheet(i).Name
+	}
+	index, err := layout.selectSheet(sheetList)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := workbook.GetSheet(index)
+	rows := make([][]string, 0, int(sheet.MaxRow)+1)
+	for i := 0; i <= int(sheet.MaxRow); i++ {
+		rows = append(rows, xlsRow(sheet, i))
+	}
+	sheetRows := newSliceRowSource(rows)
+	sheetRows.sheet = sheetList[index]
+	return sheetRows, nil
+}
+
+func xlsRow(sheet *xls.WorkSheet, index int) (columns []string) {
+	defer func() {
+		if recover() != nil {
+			columns = nil
+		}
+	}()
+
+	row := sheet.Row(index)
+	for i := 0; i <= row.LastCol(); i++ {
+		columns = append(columns, row.Col(i))
+	}
+	return trimTrailingEmpty(columns)
+}
+
+func openODS(data []byte, layout *SheetLayout) (RowSource, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	content, err := archive.Open("content.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	rows, name, err := readODSTable(content, layout)
+	if err != nil {
+		return nil, err
+	}
+	source := newSliceRowSource(rows)
+	source.sheet = name
+	return source, nil
+}
+
+// maxODSRepeat caps repeated rows and cells, spreadsheet applications pad sheets with up to a million empty rows.
+const maxODSRepeat = 1000
+
+// readODSTable reads the rows and the name of the table selected by the layout from an OpenDocument content.xml.
+func readODSTable(r io.Reader, layout *SheetLayout) ([][]string, string, error) {
+	const tableNS = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	const officeNS = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	const textNS = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+
+	var (
+		rows        [][]string
+		row         []string
+		rowRepeat   int
+		cell        strings.Builder
+		cellValue   string
+		cellRepeat  int
+		inTable     bool
+		tableName   string
+		tables      int
+		inCell      bool
+		paragraphs  int
+		decoder     = xml.NewDecoder(r)
+		repeatValue = func(attrs []xml.Attr, name string) int {
+			for _, attr := range attrs {
+				if attr.Name.Space == tableNS && attr.Name.Local == name {
+					if n, err := strconv.Atoi(attr.Value); err == nil && n > 0 {
+						return min(n, maxODSRepeat)
+					}
+				}
+			}
+			return 1
+		}
+	)
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, "", fmt.Errorf("%w: %s", ErrSheetNotFound, layout.sheetDescription())
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == tableNS && t.Name.Local == "table":
+				name := ""
+				for _, attr := range t.Attr {
+					if attr.Name.Space == tableNS && attr.Name.Local == "name" {
+						name = attr.Value
+					}
+				}
+				inTable = layout.matchesSheet(tables, name)
+				if inTable {
+					tableName = name
+				}
+				tables++
+			case inTable && t.Name.Space == tableNS && t.Name.Local == "table-row":
+				row = nil
+				rowRepeat = repeatValue(t.Attr, "number-rows-repeated")
+			case inTable && t.Name.Space == tableNS && (t.Name.Local == "table-cell" || t.Name.Local == "covered-table-cell"):
+				inCell = true
+				paragraphs = 0
+				cell.Reset()
+				cellValue = ""
+				cellRepeat = repeatValue(t.Attr, "number-columns-repeated")
+				for _, attr := range t.Attr {
+					// numbers and dates carry their unformatted value as attribute
+					if attr.Name.Space == officeNS && (attr.Name.Local == "value" || attr.Name.Local == "date-value" || attr.Name.Local == "boolean-value") {
+						cellValue = attr.Value
+					}
+				}
+			case inCell && t.Name.Space == textNS && t.Name.Local == "p":
+				if paragraphs > 0 {
+					cell.WriteString("\n")
+				}
+				paragraphs++
+			case inCell && t.Name.Space == textNS && t.Name.Local == "s":
+				cell.WriteString(" ")
+			}
+		case xml.CharData:
+			if inCell {
+				cell.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case inTable && t.Name.Space == tableNS && (t.Name.Local == "table-cell" || t.Name.Local == "covered-table-cell"):
+				inCell = false
+				value := cellValue
+				if value == "" {
+					value = cell.String()
+				}
+				for i := 0; i < cellRepeat; i++ {
+					row = append(row, value)
+				}
+			case inTable && t.Name.Space == tableNS && t.Name.Local == "table-row":
+				row = trimTrailingEmpty(row)
+				for i := 0; i < rowRepeat; i++ {
+					rows = append(rows, row)
+				}
+			case inTable && t.Name.Space == tableNS && t.Name.Local == "table":
+				return trimTrailingEmptyRows(rows), tableName, nil
+			}
+		}
+	}
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+type csvRowSource struct {
+	reader  *csv.Reader
+	columns []string
+	err     error
+}
+
+func openCSV(data []byte, charset string) (RowSource, error) {
+	decoded, err := decodeText(data, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(decoded))
+	reader.Comma = sniffDelimiter(decoded)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return &csvRowSource{reader: reader}, nil
+}
+
+func (s *csvRowSource) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	s.columns, s.err = s.reader.Read()
+	return s.err == nil
+}
+
+func (s *csvRowSource) Columns() ([]string, error) {
+	if s.err != nil && !errors.Is(s.err, io.EOF) {
+		return nil, s.err
+	}
+	return s.columns, nil
+}
+
+// Err returns the error that stopped Next, it is nil at the end of the input.
+func (s *csvRowSource) Err() error {
+	if errors.Is(s.err, io.EOF) {
+		return nil
+	}
+	return s.err
+}
+
+func (s *csvRowSource) Close() error {
+	return nil
+}
+
+// decodeText converts the input to UTF-8. Without an explicit charset it relies on byte order marks,
+// accepts valid UTF-8 as is and otherwise falls back to Windows-1252, or to GB18030 if looksLikeGB2312.
+func decodeText(data []byte, charset string) ([]byte, error) {
+	var enc encoding.Encoding
+	switch {
+	case charset != "":
+		var err error
+		enc, err = htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unknown charset %q: %w", charset, err)
+		}
+	case bytes.HasPrefix(data, utf8BOM):
+		return data[len(utf8BOM):], nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		enc = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	case utf8.Valid(data):
+		return data, nil
+	default:
+		enc = charmap.Windows1252
+		if looksLikeGB2312(data) {
+			if decoded, _, err := transform.Bytes(simplifiedchinese.GB18030.NewDecoder(), data); err == nil && !bytes.ContainsRune(decoded, utf8.RuneError) {
+				return decoded, nil
+			}
+		}
+	}
+
+	decoded, _, err := transform.Bytes(enc.NewDecoder(), data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(decoded, utf8BOM), nil
+}
+
+// looksLikeGB2312 reports whether at least 90% of the non-ASCII characters of the text are Chinese characters of
+// GB2312, the common subset of GB18030, whose two bytes are both in 0xA1-0xFE. GB18030 also decodes almost any
+// Windows-1252 text, "M\xfcller" would become "M黮ler", but there umlauts and accents are mostly next to ASCII letters.
+func looksLikeGB2312(data []byte) bool {
+	pairs, others := 0, 0
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] < 0x80:
+		case data[i] >= 0xA1 && data[i] <= 0xFE && i+1 < len(data) && data[i+1] >= 0xA1 && data[i+1] <= 0xFE:
+			pairs++
+			i++
+		default:
+			others++
+		}
+	}
+	return pairs > 0 && pairs >= 9*others
+}
+
+// sniffDelimiter picks the delimiter that occurs most often outside of quotes in the first line.
+func sniffDelimiter(data []byte) rune {
+	candidates := []rune{',', ';', '\t', '|'}
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range string(data[:min(len(data), 64<<10)]) {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if !quoted && (r == '\n' || r == '\r') {
+			break
+		}
+		if !quoted {
+			counts[r]++
+		}
+	}
+
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if counts[candidate] > counts[best] {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// openJSON accepts an array of arrays, where the first array is the header row,
+// or an array of objects, whose keys become the header row in order of appearance.
+func openJSON(data []byte) (RowSource, error) {
+	decoder := json.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM)))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, errors.New("json input must be an array")
+	}
+
+	var (
+		rows    [][]string
+		headers []string
+		objects []map[string]string
+		index   = make(map[string]int)
+	)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token {
+		case json.Delim('['):
+			var row []string
+			for decoder.More() {
+				value, err := readJSONScalar(decoder)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, value)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		case json.Delim('{'):
+			object := make(map[string]string)
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := keyToken.(string)
+				value, err := readJSONScalar(decoder)
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := index[key]; !ok {
+					index[key] = len(headers)
+					headers = append(headers, key)
+				}
+				object[key] = value
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+		default:
+			return nil, errors.New("json array elements must be arrays or objects")
+		}
+	}
+
+	if len(objects) > 0 {
+		if len(rows) > 0 {
+			return nil, errors.New("json input mixes arrays and objects")
+		}
+		rows = append(rows, headers)
+		for _, object := range objects {
+			row := make([]string, len(headers))
+			for key, value := range object {
+				row[index[key]] = value
+			}
+			rows = append(rows, row)
+		}
+	}
+	return newSliceRowSource(rows), nil
+}
+
+func readJSONScalar(decoder *json.Decoder) (string, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	switch value := token.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", errors.New("json cells must be strings, numbers, booleans or null")
+}
+
+func trimTrailingEmpty(columns []string) []string {
+	for len(columns) > 0 && strings.TrimSpace(columns[len(columns)-1]) == "" {
+		columns = columns[:len(columns)-1]
+	}
+	return columns
+}
+
+func trimTrailingEmptyRows(rows [][]string) [][]string {
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	return rows
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want rune
+	}{
+		{"comma", "a,b,c\n1,2,3\n", ','},
+		{"semicolon", "a;b;c\n1,5;2,5;3\n", ';'},
+		{"tab", "a\tb\tc\n", '\t'},
+		{"pipe", "a|b|c", '|'},
+		{"single column", "a\n1\n", ','},
+		{"quoted delimiters", "\"a,b\";\"c,d\";e\n", ';'},
+		{"only the first line", "a;b\n1,2,3,4,5\n", ';'},
+		{"quoted line break", "\"a\nb,c,d,e\";f;g\n", ';'},
+		{"carriage return", "a|b\r\n1,2,3\r\n", '|'},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sniffDelimiter([]byte(test.data)); got != test.want {
+				t.Errorf("sniffDelimiter(%q) = %q, want %q", test.data, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		charset string
+		want    string
+	}{
+		{"utf-8", []byte("Straße"), "", "Straße"},
+		{"utf-8 with byte order mark", []byte("\xef\xbb\xbfStraße"), "", "Straße"},
+		{"utf-16 little endian", []byte("\xff\xfeS\x00\xdf\x00"), "", "Sß"},
+		{"utf-16 big endian", []byte("\xfe\xff\x00S\x00\xdf"), "", "Sß"},
+		{"gb18030", []byte("\xc4\xe3\xba\xc3"), "", "你好"},
+		{"gb18030 manifest", []byte("\xb5\xa5\xba\xc5;\xca\xd5\xbc\xfe\xc8\xcb;\xc6\xb7\xc3\xfb\nSF1;\xd5\xc5\xc8\xfd;\xca\xd6\xbb\xfa\xbf\xc7\n"), "", "单号;收件人;品名\nSF1;张三;手机壳\n"},
+		{"windows-1252", []byte("caf\xe9"), "", "café"},
+		{"windows-1252 umlauts", []byte("M\xfcller;K\xf6ln"), "", "Müller;Köln"},
+		{"windows-1252 adjacent umlauts", []byte("Name;Gr\xf6\xdfe\nM\xfcller;K\xf6ln\n"), "", "Name;Größe\nMüller;Köln\n"},
+		{"explicit charset", []byte("Stra\xdfe"), "iso-8859-1", "Straße"},
+		{"explicit gbk", []byte("\xc4\xe3\xba\xc3"), "gbk", "你好"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeText(test.data, test.charset)
+			if err != nil || string(got) != test.want {
+				t.Errorf("decodeText(%q, %q) = %q, %v, want %q", test.data, test.charset, got, err, test.want)
+			}
+		})
+	}
+
+	if _, err := decodeText([]byte("a"), "klingon"); err == nil {
+		t.Error("decodeText with an unknown charset succeeded")
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want InputFormat
+	}{
+		{"xlsx", append([]byte("PK\x03\x04"), "[Content_Types].xml"...), InputFormatXLSX},
+		{"ods", append([]byte("PK\x03\x04"), "mimetypeapplication/vnd.oasis.opendocument.spreadsheet"...), InputFormatODS},
+		{"xls", []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), InputFormatXLS},
+		{"json", []byte(" \n[{\"a\": 1}]"), InputFormatJSON},
+		{"json with byte order mark", []byte("\xef\xbb\xbf[[\"a\"]]"), InputFormatJSON},
+		{"csv", []byte("a,b\n1,2\n"), InputFormatCSV},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sniffFormat(test.data); got != test.want {
+				t.Errorf("sniffFormat(%q) = %s, want %s", test.data, got, test.want)
+			}
+		})
+	}
+}
+
+func TestOpenCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		charset string
+		want    [][]string
+	}{
+		{"semicolon", []byte("Name;Gewicht\nMüller;1,5\n"), "", [][]string{{"Name", "Gewicht"}, {"Müller", "1,5"}}},
+		{"windows-1252", []byte("Name;Stadt\nM\xfcller;K\xf6ln\n"), "windows-1252", [][]string{{"Name", "Stadt"}, {"Müller", "Köln"}}},
+		{"ragged rows", []byte("a,b,c\n1\n"), "", [][]string{{"a", "b", "c"}, {"1"}}},
+		{"lazy quotes", []byte("a,b\n5\" screen,2\n"), "", [][]string{{"a", "b"}, {"5\" screen", "2"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := openCSV(test.data, test.charset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer source.Close()
+
+			var rows [][]string
+			for source.Next() {
+				columns, err := source.Columns()
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows = append(rows, columns)
+			}
+			if err := source.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, test.want) {
+				t.Errorf("got %q, want %q", rows, test.want)
+			}
+		})
+	}
+}
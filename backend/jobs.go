@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultJobWorkers   = 4
+	defaultJobQueueSize = 100
+	// jobRetention is how long finished jobs can be polled.
+	jobRetention = 24 * time.Hour
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobQueueFull = errors.New("job queue is full")
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job processes one uploaded manifest in the background.
+type Job struct {
+	ID         string       `json:"id"`
+	Pipeline   string       `json:"pipeline"`
+	Version    int          `json:"version,omitempty"`
+	Filename   string       `json:"filename,omitempty"`
+	DryRun     bool         `json:"dryRun,omitempty"`
+	Status     JobStatus    `json:"status"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	StartedAt  *time.Time   `json:"startedAt,omitempty"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
+	Progress   *JobProgress `json:"progress"`
+	// Result is an InputResult, a DryRunResult or the ValidationReport of a rejected manifest.
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	run    func(progress *JobProgress) (any, error)
+	onDone func(job *Job)
+}
+
+// JobProgress counts what a job has done so far, it is updated while the job runs.
+type JobProgress struct {
+	RowsRead         atomic.Int64
+	HouseWaybills    atomic.Int64
+	ObjectsPublished atomic.Int64
+	Errors           atomic.Int64
+}
+
+func (p *JobProgress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		RowsRead         int64 `json:"rowsRead"`
+		HouseWaybills    int64 `json:"houseWaybills"`
+		ObjectsPublished int64 `json:"objectsPublished"`
+		Errors           int64 `json:"errors"`
+	}{p.RowsRead.Load(), p.HouseWaybills.Load(), p.ObjectsPublished.Load(), p.Errors.Load()})
+}
+
+// The methods below do nothing on a nil progress, so that code shared with synchronous callers need not check.
+
+func (p *JobProgress) rowRead() {
+	if p != nil {
+		p.RowsRead.Add(1)
+	}
+}
+
+func (p *JobProgress) setHouseWaybills(count int) {
+	if p != nil {
+		p.HouseWaybills.Store(int64(count))
+	}
+}
+
+func (p *JobProgress) setErrors(count int) {
+	if p != nil {
+		p.Errors.Store(int64(count))
+	}
+}
+
+func (p *JobProgress) objectPublished() {
+	if p != nil {
+		p.ObjectsPublished.Add(1)
+	}
+}
+
+// JobQueue runs jobs in a fixed number of workers. Jobs are kept in memory, they are lost on restart.
+type JobQueue struct {
+	queue chan *Job
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobQueue starts the workers. JOB_WORKERS sets their number and JOB_QUEUE_SIZE how many jobs may wait.
+func NewJobQueue() (*JobQueue, error) {
+	workers, err := envInt("JOB_WORKERS", defaultJobWorkers)
+	if err != nil {
+		return nil, err
+	}
+	size, err := envInt("JOB_QUEUE_SIZE", defaultJobQueueSize)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &JobQueue{queue: make(chan *Job, size), jobs: make(map[string]*Job)}
+	for range workers {
+		go q.work()
+	}
+	return q, nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New(name + " must be a positive number")
+	}
+	return n, nil
+}
+
+// Submit queues run and returns the job. It fails with ErrJobQueueFull instead of waiting for a free slot.
+// onDone, if not nil, is called with the finished job.
+func (q *JobQueue) Submit(pipeline *Pipeline, filename string, dryRun bool, run func(progress *JobProgress) (any, error), onDone func(job *Job)) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:        id,
+		Pipeline:  pipeline.Name,
+		Version:   pipeline.Version,
+		Filename:  filename,
+		DryRun:    dryRun,
+		Status:    JobQueued,
+		CreatedAt: time.Now().UTC(),
+		Progress:  &JobProgress{},
+		run:       run,
+		onDone:    onDone,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire(job.CreatedAt)
+
+	select {
+	case q.queue <- job:
+	default:
+		return nil, ErrJobQueueFull
+	}
+	q.jobs[job.ID] = job
+	return q.snapshot(job), nil
+}
+
+// Get returns a copy of the job as it is now.
+func (q *JobQueue) Get(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return q.snapshot(job), nil
+}
+
+func (q *JobQueue) snapshot(job *Job) *Job {
+	return &Job{
+		ID:         job.ID,
+		Pipeline:   job.Pipeline,
+		Version:    job.Version,
+		Filename:   job.Filename,
+		DryRun:     job.DryRun,
+		Status:     job.Status,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		Progress:   job.Progress,
+		Result:     job.Result,
+		Error:      job.Error,
+	}
+}
+
+// expire forgets jobs that finished more than jobRetention ago.
+func (q *JobQueue) expire(now time.Time) {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// runJob turns a panic into an error, one broken manifest must not take the server down.
+func runJob(job *Job) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return job.run(job.Progress)
+}
+
+func (q *JobQueue) work() {
+	for job := range q.queue {
+		q.mu.Lock()
+		job.Status = JobRunning
+		job.StartedAt = Ptr(time.Now().UTC())
+		q.mu.Unlock()
+
+		result, err := runJob(job)
+
+		q.mu.Lock()
+		job.Result = result
+		job.Status = JobSucceeded
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+		}
+		job.FinishedAt = Ptr(time.Now().UTC())
+		job.run = nil
+		finished := q.snapshot(job)
+		q.mu.Unlock()
+
+		log.Info().Str("job", job.ID).Str("pipeline", job.Pipeline).Str("status", string(job.Status)).Dur("duration", job.FinishedAt.Sub(*job.StartedAt)).Msg("job finished")
+		if job.onDone != nil {
+			job.onDone(finished)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrSheetNotFound = errors.New("sheet not found")
+
+// SheetLayout describes where the data is located within a manifest.
+type SheetLayout struct {
+	// Sheet selects a sheet by name. If empty, SheetIndex (0-based) is used.
+	Sheet      string `json:"sheet,omitempty"`
+	SheetIndex int    `json:"sheetIndex,omitempty"`
+
+	// SkipRows is the number of leading rows, e.g. title banners, that are ignored.
+	SkipRows int `json:"skipRows,omitempty"`
+	// HeaderRow is the 1-based row number of the header. 0 means the first row after SkipRows.
+	HeaderRow int `json:"headerRow,omitempty"`
+	// NoHeader is set for manifests that start with data right away.
+	NoHeader bool `json:"noHeader,omitempty"`
+
+	Footer *FooterRule `json:"footer,omitempty"`
+}
+
+// defaultFooterPattern is suggested by /schema when a sampled row looks like a total row. \b only knows ASCII word
+// characters, the Chinese words are matched without it.
+const defaultFooterPattern = `(?i)^(?:(?:total|totals|sum|summe|gesamt|gesamtsumme|toplam)\b|合计|总计)`
+
+// FooterRule detects the end of the data, e.g. a total row. The footer row and all rows after it are ignored.
+type FooterRule struct {
+	// Pattern is a regular expression matched against the first non-empty cell of each row, e.g.
+	// "(?i)^(total|summe|合计)", so that a product named "Total Wireless Earbuds" does not end the data.
+	Pattern string `json:"pattern,omitempty"`
+	// Column matches Pattern against this 0-based column instead.
+	Column *int `json:"column,omitempty"`
+	// EmptyRows ends the data after the given number of consecutive empty rows.
+	EmptyRows int `json:"emptyRows,omitempty"`
+}
+
+// layoutFromQuery reads the layout parameters sheet, sheetIndex, skipRows, headerRow and noHeader.
+func layoutFromQuery(query url.Values) (*SheetLayout, error) {
+	layout := &SheetLayout{Sheet: query.Get("sheet")}
+	for name, value := range map[string]*int{
+		"sheetIndex": &layout.SheetIndex,
+		"skipRows":   &layout.SkipRows,
+		"headerRow":  &layout.HeaderRow,
+	} {
+		if query.Has(name) {
+			number, err := strconv.Atoi(query.Get(name))
+			if err != nil || number < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, query.Get(name))
+			}
+			*value = number
+		}
+	}
+	layout.NoHeader = query.Get("noHeader") == "true"
+
+	validationErr := &ValidationError{}
+	validateLayout(layout, validationErr)
+	if len(validationErr.Errors) > 0 {
+		return nil, validationErr
+	}
+	return layout, nil
+}
+
+func (l *SheetLayout) selectSheet(sheetList []string) (int, error) {
+	for i, name := range sheetList {
+		if l.matchesSheet(i, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrSheetNotFound, l.sheetDescription())
+}
+
+func (l *SheetLayout) matchesSheet(index int, name string) bool {
+	switch {
+	case l == nil:
+		return index == 0
+	case l.Sheet != "":
+		return strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(l.Sheet))
+	}
+	return index == l.SheetIndex
+}
+
+func (l *SheetLayout) sheetDescription() string {
+	switch {
+	case l == nil:
+		return "first sheet"
+	case l.Sheet != "":
+		return fmt.Sprintf("sheet %q", l.Sheet)
+	}
+	return fmt.Sprintf("sheet index %d", l.SheetIndex)
+}
+
+// headerRow returns the 1-based row number of the header row or 0 if there is none.
+func (l *SheetLayout) headerRow() int {
+	switch {
+	case l == nil:
+		return 1
+	case l.NoHeader:
+		return 0
+	case l.HeaderRow > 0:
+		return l.HeaderRow
+	}
+	return l.SkipRows + 1
+}
+
+func validateLayout(layout *SheetLayout, validationErr *ValidationError) {
+	if layout == nil {
+		return
+	}
+	if layout.SheetIndex < 0 {
+		validationErr.add("layout.sheetIndex", "must not be negative")
+	}
+	if layout.SkipRows < 0 {
+		validationErr.add("layout.skipRows", "must not be negative")
+	}
+	if layout.HeaderRow < 0 {
+		validationErr.add("layout.headerRow", "must not be negative")
+	}
+	if layout.HeaderRow > 0 && layout.HeaderRow <= layout.SkipRows {
+		validationErr.add("layout.headerRow", "row %d is skipped by skipRows", layout.HeaderRow)
+	}
+	if layout.HeaderRow > 0 && layout.NoHeader {
+		validationErr.add("layout.headerRow", "must not be set together with noHeader")
+	}
+	if footer := layout.Footer; footer != nil {
+		if _, err := regexp.Compile(footer.Pattern); err != nil {
+			validationErr.add("layout.footer.pattern", "invalid regular expression: %s", err)
+		}
+		if footer.Column != nil && *footer.Column < 0 {
+			validationErr.add("layout.footer.column", "must not be negative")
+		}
+		if footer.EmptyRows < 0 {
+			validationErr.add("layout.footer.emptyRows", "must not be negative")
+		}
+	}
+}
+
+// Table applies a SheetLayout to a RowSource: it skips leading rows, reads the header row,
+// drops empty rows and stops at the footer.
+type Table struct {
+	Headers []string
+	// Preamble holds the rows above the data, including the header row, e.g. a banner with the master waybill.
+	Preamble [][]string
+
+	source    RowSource
+	footer    *regexp.Regexp
+	layout    *SheetLayout
+	row       int
+	columns   []string
+	emptyRows int
+	done      bool
+
+	footerFound  bool
+	footerColumn int
+	// trailingRows counts the non-empty rows after the end of the data.
+	trailingRows int
+}
+
+func openTable(source RowSource, layout *SheetLayout) (*Table, error) {
+	table := &Table{source: source, layout: layout}
+	if layout != nil && layout.Footer != nil && layout.Footer.Pattern != "" {
+		footer, err := regexp.Compile(layout.Footer.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		table.footer = footer
+	}
+
+	skip := 0
+	if layout != nil {
+		skip = layout.SkipRows
+	}
+	headerRow := layout.headerRow()
+
+	for table.row < max(skip, headerRow) {
+		if !source.Next() {
+			if err := source.Err(); err != nil {
+				return nil, err
+			}
+			if headerRow > 0 {
+				return nil, fmt.Errorf("header row %d not found", headerRow)
+			}
+			table.done = true
+			return table, nil
+		}
+		table.row++
+		columns, err := source.Columns()
+		if table.row == headerRow {
+			if err != nil {
+				return nil, err
+			}
+			table.Headers = columns
+		}
+		// skipped rows that cannot be read stay empty
+		table.Preamble = append(table.Preamble, columns)
+	}
+	return table, nil
+}
+
+func (t *Table) Next() bool {
+	for !t.done && t.source.Next() {
+		t.row++
+		columns, err := t.source.Columns()
+		if err != nil {
+			// let Columns report the error
+			t.columns = nil
+			return true
+		}
+
+		if isEmptyRow(columns) {
+			t.emptyRows++
+			if t.layout != nil && t.layout.Footer != nil && t.layout.Footer.EmptyRows > 0 && t.emptyRows >= t.layout.Footer.EmptyRows {
+				t.done = true
+				t.skipTrailingRows()
+			}
+			continue
+		}
+		t.emptyRows = 0
+
+		if column, ok := t.isFooter(columns); ok {
+			t.done = true
+			t.footerFound = true
+			t.footerColumn = column
+			t.skipTrailingRows()
+			return false
+		}
+		t.columns = columns
+		return true
+	}
+	return false
+}
+
+// skipTrailingRows reads the rest of the source after the end of the data and counts the rows that are not empty.
+func (t *Table) skipTrailingRows() {
+	for t.source.Next() {
+		if columns, err := t.source.Columns(); err != nil || !isEmptyRow(columns) {
+			t.trailingRows++
+		}
+	}
+}
+
+func (t *Table) Columns() ([]string, error) {
+	if t.columns == nil {
+		return t.source.Columns()
+	}
+	return t.columns, nil
+}
+
+// Err returns the error that stopped Next when the source could not be read.
+func (t *Table) Err() error {
+	return t.source.Err()
+}
+
+// FooterFound reports whether the footer rule ended the table.
+func (t *Table) FooterFound() bool {
+	return t.footerFound
+}
+
+// FooterColumn returns the 0-based column in which the footer rule matched.
+func (t *Table) FooterColumn() int {
+	return t.footerColumn
+}
+
+// TrailingRows returns the number of non-empty rows that were ignored after the footer or the empty rows ending
+// the table. It is known once Next has returned false.
+func (t *Table) TrailingRows() int {
+	return t.trailingRows
+}
+
+// trailingRowsWarning tells which rows after the end of the data were ignored, or returns "" if there were none.
+func (t *Table) trailingRowsWarning() string {
+	if t.trailingRows == 0 {
+		return ""
+	}
+	end := "the empty rows"
+	if t.footerFound {
+		end = fmt.Sprintf("the footer in row %d", t.row)
+	}
+	if t.trailingRows == 1 {
+		return fmt.Sprintf("1 row after %s was ignored", end)
+	}
+	return fmt.Sprintf("%d rows after %s were ignored", t.trailingRows, end)
+}
+
+// Row returns the 1-based row number of the current row within the sheet.
+func (t *Table) Row() int {
+	return t.row
+}
+
+func (t *Table) Close() error {
+	return t.source.Close()
+}
+
+// isFooter returns the column in which the footer rule matched the row.
+func (t *Table) isFooter(columns []string) (int, bool) {
+	if t.footer == nil {
+		return 0, false
+	}
+	if column := t.layout.Footer.Column; column != nil {
+		return *column, *column < len(columns) && t.footer.MatchString(strings.TrimSpace(columns[*column]))
+	}
+	for i, value := range columns {
+		if value := strings.TrimSpace(value); value != "" {
+			return i, t.footer.MatchString(value)
+		}
+	}
+	return 0, false
+}
+
+func isEmptyRow(columns []string) bool {
+	for _, value := range columns {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// maxHeaderSearchRows limits how far down /schema looks for the header row below title banners.
+const maxHeaderSearchRows = 10
+
+// detectHeaderRow returns the index of the row that most likely is the header: the one with the most cells
+// resembling a known header, or else the first row with the most non-empty cells.
+func detectHeaderRow(rows [][]string) int {
+	bestRow, bestMatches, bestFilled, filledRow := 0, 0, 0, 0
+	for i, row := range rows[:min(len(rows), maxHeaderSearchRows)] {
+		matches, filled := 0, 0
+		for _, value := range row {
+			header := normalizeHeader(value)
+			if header == "" {
+				continue
+			}
+			filled++
+			for _, field := range schemaFields {
+				if scoreHeader(header, field) >= minSuggestionConfidence {
+					matches++
+					break
+				}
+			}
+		}
+		if matches > bestMatches {
+			bestRow, bestMatches = i, matches
+		}
+		if filled > bestFilled {
+			filledRow, bestFilled = i, filled
+		}
+	}
+	if bestMatches == 0 {
+		return filledRow
+	}
+	return bestRow
+}
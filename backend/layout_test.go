@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTableFooter(t *testing.T) {
+	header := []string{"HAWB", "Description", "Qty"}
+	tests := []struct {
+		name   string
+		footer *FooterRule
+		rows   [][]string
+		// want are the first cells of the data rows
+		want         []string
+		footerColumn int
+		trailingRows int
+	}{
+		{
+			name:   "total",
+			footer: &FooterRule{Pattern: defaultFooterPattern},
+			rows:   [][]string{{"H1", "Lamp", "1"}, {"Total", "", "1"}, {"H2", "Late", "1"}},
+			want:   []string{"H1"}, trailingRows: 1,
+		},
+		{
+			name:   "chinese total",
+			footer: &FooterRule{Pattern: defaultFooterPattern},
+			rows:   [][]string{{"H1", "灯", "1"}, {"合计", "", "1"}},
+			want:   []string{"H1"},
+		},
+		{
+			name:   "chinese total with suffix",
+			footer: &FooterRule{Pattern: defaultFooterPattern},
+			rows:   [][]string{{"H1", "灯", "1"}, {"", "总计：", "1"}, {"", "", ""}},
+			want:   []string{"H1"}, footerColumn: 1,
+		},
+		{
+			name:   "total in a product name",
+			footer: &FooterRule{Pattern: defaultFooterPattern},
+			rows:   [][]string{{"H1", "Total Wireless Earbuds", "1"}, {"H2", "Summer Hat", "1"}},
+			want:   []string{"H1", "H2"},
+		},
+		{
+			name:   "word starting with total",
+			footer: &FooterRule{Pattern: defaultFooterPattern},
+			rows:   [][]string{{"Totalview 3", "Camera", "1"}},
+			want:   []string{"Totalview 3"},
+		},
+		{
+			name:   "column",
+			footer: &FooterRule{Pattern: defaultFooterPattern, Column: Ptr(1)},
+			rows:   [][]string{{"Total", "Lamp", "1"}, {"H2", "Summe", "2"}},
+			want:   []string{"Total"}, footerColumn: 1,
+		},
+		{
+			name:   "empty rows",
+			footer: &FooterRule{EmptyRows: 2},
+			rows:   [][]string{{"H1", "Lamp", "1"}, {"", "", ""}, {"H2", "Hat", "1"}, {"", "", ""}, {"", "", ""}, {"Signed", "", ""}},
+			want:   []string{"H1", "H2"}, trailingRows: 1,
+		},
+		{
+			name: "no footer rule",
+			rows: [][]string{{"H1", "Lamp", "1"}, {"Total", "", "1"}},
+			want: []string{"H1", "Total"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, err := openTable(newSliceRowSource(append([][]string{header}, test.rows...)), &SheetLayout{HeaderRow: 1, Footer: test.footer})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for table.Next() {
+				columns, err := table.Columns()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, columns[0])
+			}
+			if err := table.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got rows %q, want %q", got, test.want)
+			}
+			if table.FooterColumn() != test.footerColumn || table.TrailingRows() != test.trailingRows {
+				t.Errorf("got footer column %d and %d trailing rows, want %d and %d",
+					table.FooterColumn(), table.TrailingRows(), test.footerColumn, test.trailingRows)
+			}
+		})
+	}
+}
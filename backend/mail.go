@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxMessageSize leaves room for the base64 encoding of an upload of maxUploadSize.
+	maxMessageSize = maxUploadSize * 3 / 2
+	maxRecipients  = 20
+	smtpTimeout    = 5 * time.Minute
+)
+
+var errMessageTooLarge = errors.New("message too large")
+
+// MailReceiver is a minimal SMTP server that accepts mail for pipelines. Every manifest attached to a mail is
+// submitted to every pipeline the mail is addressed to, the sender gets a summary once all jobs are done.
+type MailReceiver struct {
+	config   *MailConfig
+	store    PipelineStore
+	ingester *Ingester
+	hostname string
+	// maxSize is the size limit of a message, maxMessageSize except in tests.
+	maxSize int
+}
+
+func NewMailReceiver(config *MailConfig, store PipelineStore, ingester *Ingester) *MailReceiver {
+	hostname := config.Domain
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	return &MailReceiver{config: config, store: store, ingester: ingester, hostname: hostname, maxSize: maxMessageSize}
+}
+
+func (m *MailReceiver) ListenAndServe() error {
+	listener, err := net.Listen("tcp", m.config.Listen)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("addr", m.config.Listen).Msg("receiving mail")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go m.serve(conn)
+	}
+}
+
+// pipelineName returns the pipeline a recipient address belongs to.
+func (m *MailReceiver) pipelineName(address string) (string, bool) {
+	address = strings.ToLower(address)
+	for configured, pipeline := range m.config.Addresses {
+		if strings.ToLower(configured) == address {
+			return pipeline, true
+		}
+	}
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || m.config.Domain == "" || domain != strings.ToLower(m.config.Domain) {
+		return "", false
+	}
+	return local, true
+}
+
+// smtpSession is the state of one SMTP connection.
+type smtpSession struct {
+	from      string
+	pipelines []string
+}
+
+func (m *MailReceiver) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	remote := conn.RemoteAddr().String()
+
+	// reply sends a reply of one or more lines, all but the last line have a dash after the code
+	reply := func(code int, lines ...string) bool {
+		conn.SetDeadline(time.Now().Add(smtpTimeout))
+		for i, line := range lines {
+			separator := " "
+			if i < len(lines)-1 {
+				separator = "-"
+			}
+			if err := text.PrintfLine("%d%s%s", code, separator, line); err != nil {
+				log.Err(err).Str("remote", remote).Msg("write smtp reply")
+				return false
+			}
+		}
+		return true
+	}
+
+	if !reply(220, m.hostname+" ESMTP ecommerce toolkit") {
+		return
+	}
+	session := &smtpSession{}
+	for {
+		conn.SetDeadline(time.Now().Add(smtpTimeout))
+		line, err := text.ReadLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Err(err).Str("remote", remote).Msg("read smtp command")
+			}
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		var ok bool
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ok = reply(250, m.hostname)
+		case "EHLO":
+			ok = reply(250, m.hostname, fmt.Sprintf("SIZE %d", m.maxSize), "8BITMIME")
+		case "MAIL":
+			address, found := smtpPath(arg, "FROM:")
+			if !found {
+				ok = reply(501, "syntax: MAIL FROM:<address>")
+				break
+			}
+			session = &smtpSession{from: address}
+			ok = reply(250, "OK")
+		case "RCPT":
+			address, found := smtpPath(arg, "TO:")
+			switch {
+			case !found:
+				ok = reply(501, "syntax: RCPT TO:<address>")
+			case len(session.pipelines) >= maxRecipients:
+				ok = reply(452, "too many recipients")
+			default:
+				pipeline, known := m.pipelineName(address)
+				if known {
+					_, err := m.store.Get(pipeline)
+					known = err == nil
+				}
+				if !known {
+					ok = reply(550, "no pipeline for "+address)
+					break
+				}
+				if !slices.Contains(session.pipelines, pipeline) {
+					session.pipelines = append(session.pipelines, pipeline)
+				}
+				ok = reply(250, "OK")
+			}
+		case "DATA":
+			if len(session.pipelines) == 0 {
+				ok = reply(503, "need RCPT first")
+				break
+			}
+			if !reply(354, "end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			dot := text.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dot, int64(m.maxSize)+1))
+			if err == nil && len(data) > m.maxSize {
+				// discard the rest of the message so that the connection can be used again, a new DotReader
+				// would read the next commands as a message
+				_, err = io.Copy(io.Discard, dot)
+				if err == nil {
+					err = errMessageTooLarge
+				}
+			}
+			switch {
+			case errors.Is(err, errMessageTooLarge):
+				ok = reply(552, "message exceeds fixed maximum message size")
+			case err != nil:
+				log.Err(err).Str("remote", remote).Msg("read mail")
+				return
+			default:
+				go m.handleMessage(session.from, session.pipelines, data)
+				ok = reply(250, "OK queued")
+			}
+			session = &smtpSession{}
+		case "RSET":
+			session = &smtpSession{}
+			ok = reply(250, "OK")
+		case "NOOP":
+			ok = reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			ok = reply(502, "command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// smtpPath reads the address of "FROM:<address>" or "TO:<address>", parameters such as SIZE=123 are ignored.
+func smtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	path, _, _ = strings.Cut(path, " ")
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", false
+	}
+	return path[1 : len(path)-1], true
+}
+
+// Attachment is a file attached to a mail.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// handleMessage submits the attachments of a mail and replies with a summary.
+func (m *MailReceiver) handleMessage(from string, pipelines []string, data []byte) {
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		log.Err(err).Str("from", from).Msg("parse mail")
+		return
+	}
+	subject := decodeHeader(message.Header.Get("Subject"))
+	logger := log.With().Str("from", from).Str("subject", subject).Strs("pipelines", pipelines).Logger()
+
+	attachments, err := readAttachments(textproto.MIMEHeader(message.Header), message.Body)
+	if err != nil {
+		logger.Err(err).Msg("read attachments")
+	}
+
+	// results are in the order of the pipelines and attachments, not in the order the jobs finish
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []string
+	)
+	report := func(result string) func(string) {
+		mu.Lock()
+		defer mu.Unlock()
+		index := len(results)
+		results = append(results, result)
+		return func(result string) {
+			mu.Lock()
+			results[index] = result
+			mu.Unlock()
+		}
+	}
+
+	dryRun := strings.Contains(strings.ToLower(subject), "[dry run]")
+	for _, name := range pipelines {
+		pipeline, err := m.store.Get(name)
+		if err != nil {
+			report(fmt.Sprintf("Pipeline %s: %v\n", name, err))
+			continue
+		}
+		for _, attachment := range attachments {
+			source, err := openRowSource(bytes.NewReader(attachment.Data), attachment.ContentType, attachment.Filename, pipeline.Mapping.Layout)
+			if err != nil {
+				report(fmt.Sprintf("%s (pipeline %s): %v\n", attachment.Filename, name, err))
+				continue
+			}
+			update := report("")
+			wg.Add(1)
+			_, err = m.ingester.Submit(pipeline, source, attachment.Filename, SubmitOptions{DryRun: dryRun}, func(job *Job) {
+				defer wg.Done()
+				update(jobSummary(job))
+			})
+			if err != nil {
+				wg.Done()
+				update(fmt.Sprintf("%s (pipeline %s): %v\n", attachment.Filename, name, err))
+			}
+		}
+	}
+	wg.Wait()
+
+	var body strings.Builder
+	if len(attachments) == 0 {
+		body.WriteString("The mail has no attached manifest. Attach XLSX, XLS, ODS, CSV or JSON files.\n")
+	}
+	for _, result := range results {
+		body.WriteString(result)
+		body.WriteString("\n")
+	}
+	logger.Info().Int("attachments", len(attachments)).Msg("processed mail")
+
+	if err := m.reply(from, message.Header, subject, body.String()); err != nil {
+		logger.Err(err).Msg("send summary")
+	}
+}
+
+// readAttachments walks the MIME parts of a mail and returns every part with a file name in a supported format.
+func readAttachments(header textproto.MIMEHeader, body io.Reader) ([]*Attachment, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var attachments []*Attachment
+		for {
+			part, err := reader.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return attachments, nil
+			}
+			if err != nil {
+				return attachments, err
+			}
+			nested, err := readAttachments(part.Header, part)
+			attachments = append(attachments, nested...)
+			if err != nil {
+				return attachments, err
+			}
+		}
+	}
+
+	filename := ""
+	if _, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		filename = dispositionParams["filename"]
+	}
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = filepath.Base(decodeHeader(filename))
+	if _, ok := extensionFormats[strings.ToLower(filepath.Ext(filename))]; !ok {
+		return nil, nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxUploadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filename, err)
+	}
+	if len(data) > maxUploadSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", filename, maxUploadSize)
+	}
+	return []*Attachment{{Filename: filename, ContentType: header.Get("Content-Type"), Data: data}}, nil
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// jobSummary describes the outcome of a job in the reply to the sender.
+func jobSummary(job *Job) string {
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%s (pipeline %s): %s\n", job.Filename, job.Pipeline, job.Status)
+	fmt.Fprintf(&summary, "  job: %s\n", job.ID)
+
+	var report *ValidationReport
+	switch result := job.Result.(type) {
+	case *InputResult:
+		if result.LogisticsObjectURL != "" {
+			fmt.Fprintf(&summary, "  master waybill: %s\n", result.LogisticsObjectURL)
+		}
+		fmt.Fprintf(&summary, "  house waybills: %d, logistics objects created: %d, changed: %d\n", len(result.HouseWaybills), result.Created, result.Changed)
+		if result.Outbox != nil {
+			fmt.Fprintf(&summary, "  submission %s is %s\n", result.Outbox.ID, result.Outbox.Status)
+		}
+		report = result.Report
+	case DryRunResult:
+		fmt.Fprintf(&summary, "  dry run: master waybill %s, house waybills: %d, pieces: %d, items: %d, gross weight: %g %s\n",
+			result.Summary.MasterWaybill, result.Summary.HouseWaybills, result.Summary.Pieces, result.Summary.Items,
+			result.Summary.TotalGrossWeight, result.Summary.WeightUnit)
+		report = result.Report
+	case *ValidationReport:
+		report = result
+	}
+
+	if job.Error != "" && report == nil {
+		fmt.Fprintf(&summary, "  error: %s\n", job.Error)
+	}
+	if report != nil {
+		for _, warning := range report.Warnings {
+			fmt.Fprintf(&summary, "  warning: %s\n", warning)
+		}
+	}
+	if report != nil && len(report.Errors) > 0 {
+		fmt.Fprintf(&summary, "  %d invalid values in %d rows", len(report.Errors), report.Rows)
+		if len(report.SkippedHouseWaybills) > 0 {
+			fmt.Fprintf(&summary, ", skipped house waybills: %s", strings.Join(report.SkippedHouseWaybills, ", "))
+		}
+		summary.WriteString("\n")
+		for _, rowError := range report.Errors {
+			fmt.Fprintf(&summary, "    row %d, %s: %s\n", rowError.Row, rowError.Field, rowError.Reason)
+		}
+	}
+	return summary.String()
+}
+
+// reply sends the summary to the sender. Bounces and automatic mail get no reply, so that mail loops are avoided.
+func (m *MailReceiver) reply(from string, header mail.Header, subject, body string) error {
+	if from == "" || strings.HasPrefix(strings.ToLower(from), "mailer-daemon@") {
+		return nil
+	}
+	if autoSubmitted := header.Get("Auto-Submitted"); autoSubmitted != "" && !strings.EqualFold(autoSubmitted, "no") {
+		return nil
+	}
+
+	to := from
+	if replyTo, err := mail.ParseAddress(header.Get("Reply-To")); err == nil {
+		to = replyTo.Address
+	}
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+
+	var message bytes.Buffer
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(&message, "%s: %s\r\n", name, value)
+	}
+	writeHeader("From", m.config.From)
+	writeHeader("To", to)
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	if messageID := header.Get("Message-ID"); messageID != "" {
+		writeHeader("In-Reply-To", messageID)
+		writeHeader("References", messageID)
+	}
+	writeHeader("Auto-Submitted", "auto-replied")
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", "text/plain; charset=utf-8")
+	writeHeader("Content-Transfer-Encoding", "quoted-printable")
+	message.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&message)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	if m.config.Relay == nil {
+		log.Info().Str("to", to).Str("subject", subject).Msg("no SMTP relay configured, summary not sent:\n" + body)
+		return nil
+	}
+
+	var auth smtp.Auth
+	if m.config.Relay.Username != "" {
+		host, _, _ := net.SplitHostPort(m.config.Relay.Addr)
+		auth = smtp.PlainAuth("", m.config.Relay.Username, m.config.Relay.Password, host)
+	}
+	return smtp.SendMail(m.config.Relay.Addr, auth, m.config.From, []string{to}, message.Bytes())
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMail = "From: Seller <seller@example.com>\r\n" +
+	"To: temu@ecom-pipeline.net\r\n" +
+	"Subject: Manifest [dry run]\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"see attachment\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/csv; name=\"manifest.csv\"\r\n" +
+	"Content-Disposition: attachment; filename=\"manifest.csv\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"SEFXQixXZWlnaHQKVDEsMgo=\r\n" + // HAWB,Weight\nT1,2\n
+	"--b1--\r\n"
+
+// smtpStep is a command of the client and the reply code it expects. Data is sent after a DATA command.
+type smtpStep struct {
+	command string
+	data    string
+	code    int
+}
+
+func TestMailReceiver(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []smtpStep
+		// filename is the attachment that is submitted as a job, if any
+		filename string
+	}{
+		{
+			name: "unknown recipient",
+			steps: []smtpStep{
+				{command: "HELO client", code: 250},
+				{command: "MAIL FROM:<seller@example.com>", code: 250},
+				{command: "RCPT TO:<unknown@ecom-pipeline.net>", code: 550},
+				{command: "RCPT TO:<seller@other.net>", code: 550},
+				{command: "DATA", code: 503},
+				{command: "QUIT", code: 221},
+			},
+		},
+		{
+			name: "manifest",
+			steps: []smtpStep{
+				{command: "EHLO client", code: 250},
+				{command: "MAIL FROM:<seller@example.com> SIZE=1000", code: 250},
+				{command: "RCPT TO:<Temu@ecom-pipeline.net>", code: 250},
+				{command: "RCPT TO:<temu-fra@ecom-pipeline.net>", code: 250},
+				{command: "DATA", data: testMail, code: 250},
+				{command: "QUIT", code: 221},
+			},
+			filename: "manifest.csv",
+		},
+		{
+			name: "too large",
+			steps: []smtpStep{
+				{command: "HELO client", code: 250},
+				{command: "MAIL FROM:<seller@example.com>", code: 250},
+				{command: "RCPT TO:<temu@ecom-pipeline.net>", code: 250},
+				{command: "DATA", data: testMail + strings.Repeat("x", 2048) + "\r\n", code: 552},
+				// the connection can be used for the next message
+				{command: "NOOP", code: 250},
+				{command: "MAIL FROM:<seller@example.com>", code: 250},
+				{command: "QUIT", code: 221},
+			},
+		},
+		{
+			name: "syntax",
+			steps: []smtpStep{
+				{command: "HELO client", code: 250},
+				{command: "MAIL seller@example.com", code: 501},
+				{command: "MAIL FROM:<seller@example.com>", code: 250},
+				{command: "RCPT TO:temu@ecom-pipeline.net", code: 501},
+				{command: "VRFY temu", code: 502},
+				{command: "QUIT", code: 221},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := NewFilePipelineStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Save(&Pipeline{Name: "temu", Mapping: &Schema{}}, PipelineActionCreate); err != nil {
+				t.Fatal(err)
+			}
+			// without workers the submitted jobs stay in the queue
+			jobs := &JobQueue{queue: make(chan *Job, 10), jobs: make(map[string]*Job)}
+			config := &MailConfig{Domain: "ecom-pipeline.net", Addresses: map[string]string{"temu-fra@ecom-pipeline.net": "temu"}}
+			receiver := NewMailReceiver(config, store, &Ingester{jobs: jobs})
+			receiver.maxSize = 1024
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go receiver.serve(server)
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+
+			text := textproto.NewConn(client)
+			if _, _, err := text.ReadResponse(220); err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range test.steps {
+				if err := text.PrintfLine("%s", step.command); err != nil {
+					t.Fatal(err)
+				}
+				if step.data != "" {
+					if _, _, err := text.ReadResponse(354); err != nil {
+						t.Fatalf("%s: %v", step.command, err)
+					}
+					dot := text.DotWriter()
+					if _, err := dot.Write([]byte(step.data)); err != nil {
+						t.Fatal(err)
+					}
+					if err := dot.Close(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if code, message, err := text.ReadResponse(step.code); err != nil {
+					t.Fatalf("%s: got %d %s, want %d", step.command, code, message, step.code)
+				}
+			}
+
+			if test.filename == "" {
+				if len(jobs.queue) > 0 {
+					t.Errorf("got %d jobs, want none", len(jobs.queue))
+				}
+				return
+			}
+			select {
+			case job := <-jobs.queue:
+				if job.Filename != test.filename || job.Pipeline != "temu" || !job.DryRun {
+					t.Errorf("got job for %s of pipeline %s, dry run %v", job.Filename, job.Pipeline, job.DryRun)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no job submitted")
+			}
+			if len(jobs.queue) > 0 {
+				t.Errorf("got %d more jobs, want one job for both recipients of the same pipeline", len(jobs.queue))
+			}
+		})
+	}
+}
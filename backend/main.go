@@ -1,28 +1,41 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
-	"unicode/utf8"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/xuri/excelize/v2"
 )
 
 type Pipeline struct {
-	Name    string  `json:"name"`
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"`
+	// Server names the ONE Record server from the config, the default server is used if it is empty.
+	Server  string  `json:"server,omitempty"`
 	Mapping *Schema `json:"mapping"`
 }
 
+type InputResult struct {
+	LogisticsObjectURL string                        `json:"logisticsObjectUrl"`
+	HouseWaybills      map[HouseWaybillNumber]string `json:"houseWaybills"`
+	Created            int                           `json:"created"`
+	Changed            int                           `json:"changed"`
+	Report             *ValidationReport             `json:"report"`
+	// Outbox is set when the submission failed, it is retried in the background unless it is a dead letter.
+	Outbox *OutboxEntry `json:"outbox,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
 type SchemaSuggestion struct {
 	Schema
 	Headers []string `json:"headers"`
@@ -42,6 +55,11 @@ type Schema struct {
 	ShipperState        ColumnMapping `json:"shipperState"`
 	ShipperPostcode     ColumnMapping `json:"shipperPostcode"`
 	ShipperCountry      ColumnMapping `json:"shipperCountry"`
+	ShipperEmail        ColumnMapping `json:"shipperEmail"`
+	ShipperPhone        ColumnMapping `json:"shipperPhone"`
+	ShipperEORI         ColumnMapping `json:"shipperEori"`
+	ShipperVAT          ColumnMapping `json:"shipperVat"`
+	ShipperIOSS         ColumnMapping `json:"shipperIoss"`
 
 	RecipientName         ColumnMapping `json:"recipientName"`
 	RecipientAddressLine1 ColumnMapping `json:"recipientAddressLine1"`
@@ -52,14 +70,69 @@ type Schema struct {
 	RecipientPostcode     ColumnMapping `json:"recipientPostcode"`
 	RecipientCounty       ColumnMapping `json:"recipientCounty"`
 	RecipientCountry      ColumnMapping `json:"recipientCountry"`
+	RecipientEmail        ColumnMapping `json:"recipientEmail"`
+	RecipientPhone        ColumnMapping `json:"recipientPhone"`
+	RecipientEORI         ColumnMapping `json:"recipientEori"`
+	RecipientVAT          ColumnMapping `json:"recipientVat"`
+
+	NotifyName  ColumnMapping `json:"notifyName"`
+	NotifyEmail ColumnMapping `json:"notifyEmail"`
+	NotifyPhone ColumnMapping `json:"notifyPhone"`
 
 	TotalShipmentGrossWeight ColumnMapping `json:"totalShipmentGrossWeight"`
+	ItemGrossWeight          ColumnMapping `json:"itemGrossWeight"`
 	ItemUnitPriceConcurrency ColumnMapping `json:"itemUnitPriceConcurrency"`
 	ProductSKU               ColumnMapping `json:"productSku"`
 	ShipmentGoodsDescription ColumnMapping `json:"shipmentGoodsDescription"`
 	ProductHSCode            ColumnMapping `json:"productHSCode"`
 	ItemQuantity             ColumnMapping `json:"itemQuantity"`
 	ItemPrice                ColumnMapping `json:"itemPrice"`
+
+	Layout *SheetLayout `json:"layout,omitempty"`
+	// OnInvalidRow is "reject" (default) or "skip".
+	OnInvalidRow InvalidRowPolicy `json:"onInvalidRow,omitempty"`
+	// DecimalSeparator is "." or "," for prices, weights and quantities. If it is empty, it is guessed from each
+	// value and values such as "1,234" that could be read either way are rejected.
+	DecimalSeparator string `json:"decimalSeparator,omitempty"`
+	// WeightUnit is the unit of weights without a unit suffix: KGM (default), GRM, LBR or ONZ. Weights are sent
+	// in kilograms.
+	WeightUnit string `json:"weightUnit,omitempty"`
+	// QuantityUnit is the UN/ECE Recommendation 20 code sent with item quantities, H87 (piece) by default.
+	QuantityUnit string `json:"quantityUnit,omitempty"`
+	// Lookups are tables for the lookup function of expressions, e.g. {"country_map": {"Deutschland": "DE"}}.
+	Lookups map[string]map[string]string `json:"lookups,omitempty"`
+	// Template builds the house waybills from a JSON-LD template instead of the fields above, only the waybill
+	// mapping is used. See template.go.
+	Template map[string]any `json:"template,omitempty"`
+
+	// template is the compiled Template, bound to the columns of a manifest by resolveColumns.
+	template *template
+}
+
+// weight returns a weight of the manifest in kilograms or nil if the value is empty or not a weight.
+func (s *Schema) weight(value string) *Value {
+	weightUnit := s.WeightUnit
+	if weightUnit == "" {
+		weightUnit = unitKilogram
+	}
+	kilograms, ok := parseWeight(value, weightUnit, s.DecimalSeparator)
+	if !ok {
+		return nil
+	}
+	return newWeight(kilograms)
+}
+
+// quantity returns the quantity of an item or nil if the value is empty or not a number.
+func (s *Schema) quantity(value string) *Value {
+	quantity, ok := parseQuantity(value, s.DecimalSeparator)
+	if !ok {
+		return nil
+	}
+	quantityUnit := s.QuantityUnit
+	if quantityUnit == "" {
+		quantityUnit = unitPiece
+	}
+	return newValue(quantity, newCodeListElement(quantityUnit, pieceUnitCodeListReference, pieceUnitCodeListVersion))
 }
 
 type ColumnMapping struct {
@@ -69,53 +142,168 @@ type ColumnMapping struct {
 	Column      *int    `json:"column"`
 	Constant    *string `json:"constant"`
 	UseFilename *bool   `json:"useFilename"`
+	// UseSheetName takes the value from the name of the sheet.
+	UseSheetName *bool `json:"useSheetName,omitempty"`
+	// Cells takes the value from a cell or a range such as "A1:D3" above the data, e.g. a banner.
+	Cells string `json:"cells,omitempty"`
+	// Expression computes the value from several columns, e.g. concat($M, " ", $N), see expression.go.
+	Expression string `json:"expression,omitempty"`
+	// Pattern is a regular expression that extracts the value: the group named "value", the first group or the match.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Letter binds the mapping to a spreadsheet column such as "AB".
+	Letter string `json:"letter,omitempty"`
+	// Header binds the mapping to the column with this header, Aliases are tried if it is missing.
+	Header  string   `json:"header,omitempty"`
+	Aliases []string `json:"aliases,omitempty"`
+
+	Confidence *float64 `json:"confidence,omitempty"`
+	Samples    []string `json:"samples,omitempty"`
+
+	// expression is the parsed Expression, bound to the columns of a manifest by resolveColumns.
+	expression *expression
 }
 
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 
+	store, err := openPipelineStore()
+	if err != nil {
+		log.Fatal().Err(err).Msg("open pipeline store")
+	}
+	defer store.Close()
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("load config")
+	}
+	oneRecord := NewOneRecordClients(&config.OneRecord)
+
+	publications, err := openPublicationStore()
+	if err != nil {
+		log.Fatal().Err(err).Msg("open publication store")
+	}
+
+	outbox, err := openOutbox(oneRecord, publications)
+	if err != nil {
+		log.Fatal().Err(err).Msg("open outbox")
+	}
+	go outbox.Run(context.Background())
+
+	jobs, err := NewJobQueue()
+	if err != nil {
+		log.Fatal().Err(err).Msg("start job queue")
+	}
+	ingester := &Ingester{oneRecord: oneRecord, publications: publications, outbox: outbox, jobs: jobs}
+
+	if config.Mail != nil {
+		receiver := NewMailReceiver(config.Mail, store, ingester)
+		go func() {
+			if err := receiver.ListenAndServe(); err != nil {
+				log.Fatal().Err(err).Msg("receive mail")
+			}
+		}()
+	}
+
+	if config.Folders != nil {
+		watcher := NewFolderWatcher(config.Folders.Dir, store, ingester)
+		go watcher.Run(context.Background())
+		if config.Folders.SFTP != nil {
+			server := NewSFTPServer(config.Folders.SFTP, store, watcher)
+			go func() {
+				if err := server.ListenAndServe(); err != nil {
+					log.Fatal().Err(err).Msg("serve SFTP")
+				}
+			}()
+		}
+	}
+
 	mux := http.NewServeMux()
 
 	mux.Handle("/schema", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		file, err := excelize.OpenReader(r.Body)
-		if err != nil {
-			log.Err(err).Msg("read excel")
-			w.WriteHeader(http.StatusBadRequest)
-			return
+		sampleRows := defaultSampleRows
+		if value := r.URL.Query().Get("rows"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > maxSampleRows {
+				log.Error().Str("rows", value).Msg("invalid number of sample rows")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sampleRows = n
 		}
 
-		sheetList := file.GetSheetList()
-		if len(sheetList) == 0 {
-			log.Err(err).Msg("get sheet list")
+		layout, err := layoutFromQuery(r.URL.Query())
+		if err != nil {
+			log.Err(err).Msg("read layout")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		firstSheet := sheetList[0]
 
-		rows, err := file.Rows(firstSheet)
+		source, _, err := openRequestRowSource(r, layout)
 		if err != nil {
-			log.Err(err).Msg("create row iterator")
+			log.Err(err).Msg("read input")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		defer source.Close()
 
-		if !rows.Next() {
-			log.Err(err).Msg("get sheet list")
-			w.WriteHeader(http.StatusBadRequest)
+		// buffer enough rows to find the header below title banners
+		var buffered [][]string
+		for len(buffered) < layout.SkipRows+maxHeaderSearchRows+sampleRows && source.Next() {
+			columns, err := source.Columns()
+			if err != nil {
+				log.Err(err).Msg("read row")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			buffered = append(buffered, columns)
+		}
+		if err := source.Err(); err != nil {
+			log.Err(err).Msg("read input")
+			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
+		}
+		if layout.HeaderRow == 0 && !layout.NoHeader && len(buffered) > layout.SkipRows {
+			layout.HeaderRow = layout.SkipRows + detectHeaderRow(buffered[layout.SkipRows:]) + 1
+		}
 
+		suggestFooter := layout.Footer == nil
+		if suggestFooter {
+			layout.Footer = &FooterRule{Pattern: defaultFooterPattern}
 		}
 
-		headers, err := rows.Columns()
+		table, err := openTable(newSliceRowSource(buffered), layout)
 		if err != nil {
-			log.Err(err).Msg("get sheet list")
+			log.Err(err).Msg("read header")
 			w.WriteHeader(http.StatusBadRequest)
 			return
+		}
 
+		var samples [][]string
+		for len(samples) < sampleRows && table.Next() {
+			columns, err := table.Columns()
+			if err != nil {
+				log.Err(err).Msg("read sample row")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			samples = append(samples, columns)
 		}
 
-		mapping := headersToMapping(headers)
+		mapping := headersToMapping(table.Headers, samples)
+		if suggestFooter {
+			if table.FooterFound() {
+				// later manifests of the sender have their totals in the same column
+				column := table.FooterColumn()
+				layout.Footer.Column = &column
+			} else {
+				layout.Footer = nil
+			}
+		}
+		if *layout != (SheetLayout{HeaderRow: 1}) {
+			mapping.Layout = layout
+		}
 
 		enc := json.NewEncoder(w)
 		enc.SetIndent("", "  ")
@@ -127,53 +315,32 @@ func main() {
 
 	}))
 
-	mux.Handle("/pipeline", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body, err := io.ReadAll(r.Body)
+	// kept for the frontend, creates or updates the pipeline named in the body
+	mux.Handle("POST /pipeline", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pipeline, err := decodePipeline(r, "")
 		if err != nil {
-			log.Err(err).Msg("error")
+			log.Err(err).Msg("decode pipeline")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		var pipeline Pipeline
-		if err := json.Unmarshal(body, &pipeline); err != nil {
-			log.Err(err).Msg("error")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		filename := fmt.Sprintf("%s/%s.json", PIPELINE_DIR, pipeline.Name)
 
-		if err := os.WriteFile(filename, body, 0644); err != nil {
-			log.Err(err).Msg("error")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		action := PipelineActionUpdate
+		if _, err := store.Get(pipeline.Name); errors.Is(err, ErrPipelineNotFound) {
+			action = PipelineActionCreate
 		}
+
+		version, err := savePipeline(store, pipeline, action)
+		writePipelineResponse(w, version, err)
 	}))
 
 	mux.Handle("/pipelines", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		files, err := os.ReadDir(PIPELINE_DIR)
+		pipelines, err := store.List()
 		if err != nil {
-			log.Err(err).Msg("error")
+			log.Err(err).Msg("read pipelines")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		pipelines := make([]*Pipeline, 0, len(files))
-		for _, file := range files {
-			f, err := os.Open(fmt.Sprintf("%s/%s", PIPELINE_DIR, file.Name()))
-			if err != nil {
-				log.Err(err).Msg("read pipelines")
-				continue
-			}
-			dec := json.NewDecoder(f)
-			var pipeline Pipeline
-			if err := dec.Decode(&pipeline); err != nil {
-				log.Err(err).Msg("decode pipelines")
-				continue
-			}
-			pipelines = append(pipelines, &pipeline)
-		}
-
 		enc := json.NewEncoder(w)
 		if err := enc.Encode(&pipelines); err != nil {
 			log.Err(err).Msg("write pipelines")
@@ -183,13 +350,13 @@ func main() {
 
 	}))
 
-	mux.Handle("/pipelines/{pipeline}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("GET /pipelines/{pipeline}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		pipelineName := r.PathValue("pipeline")
-		pipeline, err := readPipeline(pipelineName)
+		pipeline, err := store.Get(pipelineName)
 		if err != nil {
 			log.Err(err).Msg("error")
-			if errors.Is(err, os.ErrExist) {
+			if errors.Is(err, ErrPipelineNotFound) || errors.Is(err, ErrInvalidPipelineName) {
 				w.WriteHeader(http.StatusNotFound)
 				return
 			}
@@ -204,12 +371,83 @@ func main() {
 		}
 	}))
 
+	mux.Handle("POST /pipelines/{pipeline}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pipeline, err := decodePipeline(r, r.PathValue("pipeline"))
+		if err != nil {
+			log.Err(err).Msg("decode pipeline")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		version, err := savePipeline(store, pipeline, PipelineActionCreate)
+		writePipelineResponse(w, version, err)
+	}))
+
+	mux.Handle("PUT /pipelines/{pipeline}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pipeline, err := decodePipeline(r, r.PathValue("pipeline"))
+		if err != nil {
+			log.Err(err).Msg("decode pipeline")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		version, err := savePipeline(store, pipeline, PipelineActionUpdate)
+		writePipelineResponse(w, version, err)
+	}))
+
+	mux.Handle("DELETE /pipelines/{pipeline}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Delete(r.PathValue("pipeline")); err != nil {
+			writePipelineResponse(w, nil, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.Handle("GET /pipelines/{pipeline}/versions", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions, err := store.Versions(r.PathValue("pipeline"))
+		if err != nil {
+			writePipelineResponse(w, nil, err)
+			return
+		}
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(versions); err != nil {
+			log.Err(err).Msg("write pipeline versions")
+		}
+	}))
+
+	mux.Handle("GET /pipelines/{pipeline}/versions/{version}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number, err := strconv.Atoi(r.PathValue("version"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		version, err := store.Version(r.PathValue("pipeline"), number)
+		if err != nil {
+			writePipelineResponse(w, nil, err)
+			return
+		}
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(version); err != nil {
+			log.Err(err).Msg("write pipeline version")
+		}
+	}))
+
+	mux.Handle("POST /pipelines/{pipeline}/versions/{version}/rollback", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number, err := strconv.Atoi(r.PathValue("version"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		version, err := rollbackPipeline(store, r.PathValue("pipeline"), number)
+		writePipelineResponse(w, version, err)
+	}))
+
 	mux.Handle("/pipelines/{pipeline}/input", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		pipelineName := r.PathValue("pipeline")
-		pipeline, err := readPipeline(pipelineName)
+		pipeline, err := store.Get(pipelineName)
 		if err != nil {
 			log.Err(err).Msg("error")
-			if errors.Is(err, os.ErrExist) {
+			if errors.Is(err, ErrPipelineNotFound) || errors.Is(err, ErrInvalidPipelineName) {
 				w.WriteHeader(http.StatusNotFound)
 				return
 			}
@@ -217,50 +455,117 @@ func main() {
 			return
 		}
 
-		file, err := excelize.OpenReader(r.Body)
+		source, filename, err := openRequestRowSource(r, pipeline.Mapping.Layout)
 		if err != nil {
-			log.Err(err).Msg("read excel")
+			log.Err(err).Msg("read input")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		sheetList := file.GetSheetList()
-		if len(sheetList) == 0 {
-			log.Err(err).Msg("get sheet list")
-			w.WriteHeader(http.StatusBadRequest)
-			return
+		// the upload is read and its headers are checked, the rows are transformed and published by a worker
+		options := SubmitOptions{
+			DryRun:        r.URL.Query().Get("dryRun") == "true",
+			MasterWaybill: r.URL.Query().Get("masterWaybill"),
 		}
-		firstSheet := sheetList[0]
-
-		rows, err := file.Rows(firstSheet)
+		job, err := ingester.Submit(pipeline, source, filename, options, nil)
 		if err != nil {
-			log.Err(err).Msg("create row iterator")
-			w.WriteHeader(http.StatusBadRequest)
+			log.Err(err).Msg("submit job")
+			var unresolved *UnresolvedColumnsError
+			switch {
+			case errors.As(err, &unresolved):
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				if err := json.NewEncoder(w).Encode(unresolved); err != nil {
+					log.Err(err).Msg("write unresolved columns")
+				}
+			case errors.Is(err, ErrInvalidManifest):
+				w.WriteHeader(http.StatusBadRequest)
+			case errors.Is(err, ErrJobQueueFull):
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
 			return
 		}
 
-		filename := r.Header.Get("Content-Disposition")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			log.Err(err).Msg("write job")
+		}
+
+	}))
 
-		waybill, err := excelToOneRecord(pipeline.Mapping, rows, filename)
+	mux.Handle("GET /jobs/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobs.Get(r.PathValue("id"))
 		if err != nil {
-			log.Err(err).Msg("transform")
-			w.WriteHeader(http.StatusBadRequest)
+			log.Err(err).Msg("read job")
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			log.Err(err).Msg("write job")
+		}
+	}))
 
-		logisticsObjectUrl, err := sendWaybill(waybill)
+	mux.Handle("GET /outbox", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := OutboxStatus(r.URL.Query().Get("status"))
+		switch status {
+		case "", OutboxPending, OutboxDead, OutboxDone, OutboxSuperseded:
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		entries, err := outbox.List(status)
 		if err != nil {
+			log.Err(err).Msg("list outbox")
 			w.WriteHeader(http.StatusInternalServerError)
-			if _, err := w.Write([]byte(err.Error())); err != nil {
-				log.Err(err)
-			}
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			log.Err(err).Msg("write outbox")
+		}
+	}))
 
-		if _, err := fmt.Fprintf(w, "Logistics Object URL: %s\n", logisticsObjectUrl); err != nil {
-			log.Err(err)
+	mux.Handle("GET /outbox/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry, err := outbox.Get(r.PathValue("id"))
+		if err != nil {
+			log.Err(err).Msg("read outbox entry")
+			if errors.Is(err, ErrOutboxEntryNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entry); err != nil {
+			log.Err(err).Msg("write outbox entry")
+		}
+	}))
 
+	mux.Handle("POST /outbox/{id}/requeue", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry, err := outbox.Requeue(r.PathValue("id"))
+		if err != nil {
+			log.Err(err).Msg("requeue outbox entry")
+			switch {
+			case errors.Is(err, ErrOutboxEntryNotFound):
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, ErrNotDeadLetter):
+				w.WriteHeader(http.StatusConflict)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entry); err != nil {
+			log.Err(err).Msg("write outbox entry")
+		}
 	}))
 
 	mux.Handle("/ai/{hscode}/{term}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -303,7 +608,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // change this never :P
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(204)
@@ -316,147 +621,309 @@ func CORSMiddleware(next http.Handler) http.Handler {
 
 const PIPELINE_DIR = "pipelines"
 
-func readPipeline(pipelineName string) (*Pipeline, error) {
-	filename := fmt.Sprintf("%s/%s.json", PIPELINE_DIR, pipelineName)
-	file, err := os.Open(filename)
-	if err != nil {
+// decodePipeline reads a pipeline from the request body. A non-empty name from the URL takes precedence over the body.
+func decodePipeline(r *http.Request, name string) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	if err := json.NewDecoder(r.Body).Decode(pipeline); err != nil {
 		return nil, err
 	}
+	if name != "" {
+		if pipeline.Name != "" && pipeline.Name != name {
+			return nil, fmt.Errorf("pipeline name %q does not match %q", pipeline.Name, name)
+		}
+		pipeline.Name = name
+	}
+	return pipeline, nil
+}
 
-	dec := json.NewDecoder(file)
-	pipeline := &Pipeline{}
-	if err := dec.Decode(pipeline); err != nil {
-		return nil, err
+func writePipelineResponse(w http.ResponseWriter, version *PipelineVersion, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(validationErr); err != nil {
+			log.Err(err).Msg("write validation errors")
+		}
+		return
+	case errors.Is(err, ErrInvalidPipelineName):
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrPipelineNotFound), errors.Is(err, ErrVersionNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, ErrPipelineExists):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		log.Err(err).Msg("save pipeline")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(version); err != nil {
+		log.Err(err).Msg("write pipeline version")
 	}
-	return pipeline, err
 }
 
-func excelToOneRecord(pipeline *Schema, rows *excelize.Rows, filename string) (*Waybill, error) {
+// excelToOneRecord converts the rows of a manifest into a master waybill. Invalid rows are collected in the
+// report; depending on the pipeline policy they reject the whole file or the house waybill they belong to.
+func excelToOneRecord(pipeline *Schema, rows *Table, doc *Document, progress *JobProgress) (*Waybill, *ValidationReport, error) {
 	masterWaybill := NewMasterWaybill()
 
-	i := 0
+	skipInvalid := pipeline.OnInvalidRow == InvalidRowSkip
+	report := &ValidationReport{Policy: InvalidRowReject, Errors: []RowError{}}
+	if skipInvalid {
+		report.Policy = InvalidRowSkip
+	}
+	invalidHouseWaybills := make(map[HouseWaybillNumber]bool)
+
+	// boxes holds the pieces of each house waybill by box number, pieceWeights the sum of the item weights per piece
+	boxes := make(map[HouseWaybillNumber]map[string]*Piece)
+	pieceWeights := make(map[*Piece]float64)
+
+	masterWaybillReader, err := newMasterWaybillReader(masterWaybill, pipeline.MasterWaybillNumber, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	for rows.Next() {
 		columns, err := rows.Columns()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if len(columns) == 0 {
 			continue
 		}
 
-		houseWaybillNumber := columns[*pipeline.HouseWaybillNumber.Column]
-		if len(houseWaybillNumber) < 3 || houseWaybillNumber == pipeline.HouseWaybillNumber.Content[3:] {
-			// skip header line
+		progress.rowRead()
+
+		houseWaybillNumber := cell(columns, pipeline.HouseWaybillNumber, doc)
+		if houseWaybillNumber == "" {
 			continue
 		}
+		report.Rows++
 
-		if pipeline.MasterWaybillNumber.Column != nil {
-			masterNumber := SanitizeMawb(columns[*pipeline.MasterWaybillNumber.Column])
-			prefix, suffix := SplitMawb(masterNumber)
-			masterWaybill.WaybillNumber = suffix
-			masterWaybill.WaybillPrefix = prefix
-		} else if pipeline.MasterWaybillNumber.UseFilename != nil {
-			masterNumber := SanitizeMawb(strings.TrimSuffix(filename, ".xlsx"))
-			prefix, suffix := SplitMawb(masterNumber)
-			masterWaybill.WaybillNumber = suffix
-			masterWaybill.WaybillPrefix = prefix
-		} else if pipeline.MasterWaybillNumber.Constant != nil {
-			// TODO: review. There is no reason to have a constant master waybill number throughout the pipeline,
-			//	but there might be a reason to have a constant value such as currency and destination country
-			prefix, suffix := SplitMawb(SanitizeMawb(*pipeline.MasterWaybillNumber.Constant))
-			masterWaybill.WaybillNumber = suffix
-			masterWaybill.WaybillPrefix = prefix
+		if rowErrors := validateRow(pipeline, columns, rows.Row()); len(rowErrors) > 0 {
+			for i := range rowErrors {
+				rowErrors[i].HouseWaybill = houseWaybillNumber
+			}
+			report.Errors = append(report.Errors, rowErrors...)
+			progress.setErrors(len(report.Errors))
+			if skipInvalid && !invalidHouseWaybills[HouseWaybillNumber(houseWaybillNumber)] {
+				report.SkippedHouseWaybills = append(report.SkippedHouseWaybills, houseWaybillNumber)
+			}
+			invalidHouseWaybills[HouseWaybillNumber(houseWaybillNumber)] = true
+			delete(masterWaybill.HouseWaybills, HouseWaybillNumber(houseWaybillNumber))
+			delete(boxes, HouseWaybillNumber(houseWaybillNumber))
+			progress.setHouseWaybills(len(masterWaybill.HouseWaybills))
+			continue
+		}
+		if invalidHouseWaybills[HouseWaybillNumber(houseWaybillNumber)] {
+			continue
+		}
+
+		if err := masterWaybillReader.read(columns, rows.Row()); err != nil {
+			return nil, nil, err
+		}
+
+		value := func(mapping ColumnMapping) string {
+			return cell(columns, mapping, doc)
 		}
 
 		var houseWaybill *Waybill
 
 		item := newItem(
-			columns[*pipeline.ProductSKU.Column],
-			columns[*pipeline.ProductHSCode.Column],
-			columns[*pipeline.ItemQuantity.Column],
-			columns[*pipeline.ItemPrice.Column],
-			columns[*pipeline.ItemUnitPriceConcurrency.Column],
+			value(pipeline.ProductSKU),
+			value(pipeline.ProductHSCode),
+			pipeline.quantity(value(pipeline.ItemQuantity)),
+			newUnitPrice(value(pipeline.ItemPrice), value(pipeline.ItemUnitPriceConcurrency), pipeline.DecimalSeparator),
 		)
 
-		piece := newPiece(
-			[]*Item{item},
-			columns[*pipeline.BoxNumber.Column],
-			columns[*pipeline.ShipmentGoodsDescription.Column],
-		)
+		// rows of a house waybill with the same box number are items packed in the same piece
+		boxNumber := value(pipeline.BoxNumber)
+		houseBoxes := boxes[HouseWaybillNumber(houseWaybillNumber)]
+		piece := houseBoxes[boxNumber]
+		isNewPiece := piece == nil
+		if isNewPiece {
+			piece = newPiece(nil, boxNumber, "")
+			if boxNumber != "" {
+				if houseBoxes == nil {
+					houseBoxes = make(map[string]*Piece)
+					boxes[HouseWaybillNumber(houseWaybillNumber)] = houseBoxes
+				}
+				houseBoxes[boxNumber] = piece
+			}
+		}
+		piece.addItem(item, value(pipeline.ShipmentGoodsDescription))
+		if weight := pipeline.weight(value(pipeline.ItemGrossWeight)); weight != nil {
+			pieceWeights[piece] += float64(weight.NumericalValue)
+		}
 
 		if masterWaybill.HouseWaybills[HouseWaybillNumber(houseWaybillNumber)] != nil {
 			houseWaybill = masterWaybill.HouseWaybills[HouseWaybillNumber(houseWaybillNumber)]
-			houseWaybill.Shipment.Pieces = append(houseWaybill.Shipment.Pieces, piece)
+			if isNewPiece {
+				houseWaybill.Shipment.Pieces = append(houseWaybill.Shipment.Pieces, piece)
+			}
 		} else {
 			houseWaybill = newHouseWaybill()
-			if pipeline.ShippingReference.Column != nil {
-				houseWaybill.ShippingRef = columns[*pipeline.ShippingReference.Column]
-			}
+			houseWaybill.ShippingRef = value(pipeline.ShippingReference)
 
-			arrivalCountryCode := "DE" // TODO: implement dynamic arrival country code in pipeline
-			arrivalRegionCode := columns[*pipeline.RecipientCounty.Column]
+			arrivalCountryCode, _ := normalizeCountry(value(pipeline.RecipientCountry))
+			arrivalRegionCode := value(pipeline.RecipientCounty)
 			arrivalStreetAddressLines := []string{
-				columns[*pipeline.RecipientAddressLine1.Column],
-				columns[*pipeline.RecipientAddressLine2.Column],
-				columns[*pipeline.RecipientAddressLine3.Column],
-				columns[*pipeline.RecipientCity.Column],
-				columns[*pipeline.RecipientPostcode.Column],
+				value(pipeline.RecipientAddressLine1),
+				value(pipeline.RecipientAddressLine2),
+				value(pipeline.RecipientAddressLine3),
+				value(pipeline.RecipientCity),
+				value(pipeline.RecipientPostcode),
 			}
 			houseWaybill.ArrivalLocation = newLocation(arrivalCountryCode, arrivalRegionCode, arrivalStreetAddressLines)
 
-			departureCountryCode := columns[*pipeline.ShipperCountry.Column]
-			departureRegionCode := columns[*pipeline.ShipperState.Column]
+			departureCountryCode, _ := normalizeCountry(value(pipeline.ShipperCountry))
+			departureRegionCode := value(pipeline.ShipperState)
 			departureStreetAddressLines := []string{
-				columns[*pipeline.ShipperAddressLine1.Column],
-				columns[*pipeline.ShipperAddressLine2.Column],
-				columns[*pipeline.ShipperAddressLine3.Column],
-				columns[*pipeline.ShipperCity.Column],
-				columns[*pipeline.ShipperPostcode.Column],
+				value(pipeline.ShipperAddressLine1),
+				value(pipeline.ShipperAddressLine2),
+				value(pipeline.ShipperAddressLine3),
+				value(pipeline.ShipperCity),
+				value(pipeline.ShipperPostcode),
 			}
 			houseWaybill.DepartureLocation = newLocation(departureCountryCode, departureRegionCode, departureStreetAddressLines)
 
-			shipper := newShipper(columns[*pipeline.ShipperName.Column])
-			customer := newCustomer(columns[*pipeline.RecipientName.Column])
+			shipper := newShipper(newLogisticsAgent(
+				value(pipeline.ShipperName),
+				newContactDetails(value(pipeline.ShipperEmail), value(pipeline.ShipperPhone)),
+				houseWaybill.DepartureLocation,
+				newTaxIdentifiers(value(pipeline.ShipperEORI), value(pipeline.ShipperVAT), value(pipeline.ShipperIOSS)),
+			))
+			customer := newCustomer(newLogisticsAgent(
+				value(pipeline.RecipientName),
+				newContactDetails(value(pipeline.RecipientEmail), value(pipeline.RecipientPhone)),
+				houseWaybill.ArrivalLocation,
+				newTaxIdentifiers(value(pipeline.RecipientEORI), value(pipeline.RecipientVAT), ""),
+			))
 
 			houseWaybill.InvolvedParties = []*Party{shipper, customer}
+			if notifyName := value(pipeline.NotifyName); notifyName != "" {
+				houseWaybill.InvolvedParties = append(houseWaybill.InvolvedParties, newNotifyParty(newLogisticsAgent(
+					notifyName,
+					newContactDetails(value(pipeline.NotifyEmail), value(pipeline.NotifyPhone)),
+					nil,
+					nil,
+				)))
+			}
 
 			houseWaybill.Shipment = newShipment(
 				[]*Piece{piece},
-				columns[*pipeline.TotalShipmentGrossWeight.Column],
+				pipeline.weight(value(pipeline.TotalShipmentGrossWeight)),
 			)
 
 			if masterWaybill.HouseWaybills == nil {
 				masterWaybill.HouseWaybills = make(map[HouseWaybillNumber]*Waybill)
 			}
 			masterWaybill.HouseWaybills[HouseWaybillNumber(houseWaybillNumber)] = houseWaybill
+			progress.setHouseWaybills(len(masterWaybill.HouseWaybills))
+		}
+	}
+	// a damaged file must not pass as a shorter manifest
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read manifest after row %d: %w", rows.Row(), err)
+	}
+	if warning := rows.trailingRowsWarning(); warning != "" {
+		report.Warnings = append(report.Warnings, warning)
+	}
+
+	for number, houseWaybill := range masterWaybill.HouseWaybills {
+		houseWaybill.Shipment.setPieceWeights(pieceWeights)
+		if currencies := houseWaybill.Shipment.setDeclaredValue(); len(currencies) > 1 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("house waybill %s has prices in %s, its declared value is not set",
+				number, strings.Join(currencies, " and ")))
 		}
+	}
+	slices.Sort(report.Warnings)
 
-		i++
+	report.HouseWaybills = len(masterWaybill.HouseWaybills)
+	if len(report.Errors) > 0 && (!skipInvalid || report.HouseWaybills == 0) {
+		return nil, report, report
 	}
-	return masterWaybill, nil
+	if err := masterWaybillReader.finish(report); err != nil {
+		return nil, nil, err
+	}
+	return masterWaybill, report, nil
 }
 
-func newShipment(pieces []*Piece, totalGrossWeight string) *Shipment {
+func newShipment(pieces []*Piece, totalGrossWeight *Value) *Shipment {
 	return &Shipment{
 		Type:             "cargo:Shipment",
-		TotalGrossWeight: newTotalGrossWeight(totalGrossWeight),
+		TotalGrossWeight: totalGrossWeight,
 		Pieces:           pieces,
 	}
 }
 
-func newTotalGrossWeight(totalGrossWeight string) *Value {
-	return newValue(totalGrossWeight, newCodeListElement("KGM", weightUnitCodeListReference, ""))
+// setPieceWeights sets the gross weight of the pieces to the sum of the weights of their items. A shipment with a
+// single piece and no item weights passes its total gross weight on to the piece.
+func (s *Shipment) setPieceWeights(pieceWeights map[*Piece]float64) {
+	for _, piece := range s.Pieces {
+		if weight, ok := pieceWeights[piece]; ok {
+			piece.GrossWeight = newWeight(weight)
+		}
+	}
+	if len(s.Pieces) == 1 && s.Pieces[0].GrossWeight == nil && s.TotalGrossWeight != nil {
+		s.Pieces[0].GrossWeight = newWeight(float64(s.TotalGrossWeight.NumericalValue))
+	}
+}
+
+// setDeclaredValue sets the declared value for customs to the sum of quantity times unit price of the items. Items
+// priced in different currencies cannot be added up, the currencies are returned and the value is left unset.
+func (s *Shipment) setDeclaredValue() []string {
+	var total float64
+	var currencies []string
+	for _, piece := range s.Pieces {
+		for _, item := range piece.ContainedItems {
+			if item.UnitPrice == nil || item.ItemQuantity == nil {
+				continue
+			}
+			if !slices.Contains(currencies, item.UnitPrice.CurrencyUnit) {
+				currencies = append(currencies, item.UnitPrice.CurrencyUnit)
+			}
+			total += float64(item.ItemQuantity.NumericalValue * item.UnitPrice.NumericalValue)
+		}
+	}
+	if len(currencies) != 1 {
+		s.DeclaredValueForCustoms = nil
+		return currencies
+	}
+	s.DeclaredValueForCustoms = newCurrencyValue(roundTo(total, 2), currencies[0])
+	return currencies
+}
+
+func newWeight(kilograms float64) *Value {
+	return newValue(roundTo(kilograms, 3), newCodeListElement(unitKilogram, weightUnitCodeListReference, ""))
 }
 
-func newCustomer(customerName string) *Party {
+// newCustomer returns the consignee of a house waybill, the customer who ordered the goods.
+func newCustomer(details *LogisticsAgent) *Party {
 	customerContact := "CUSTOMER_CONTACT"
-	return newParty(customerName, &customerContact, nil)
+	details.ContactRole = &customerContact
+	return newParty(details, newCodeListElement("CNE", iataCoreCodeListReference, iataCoreCodeListVersion))
+}
+
+func newNotifyParty(details *LogisticsAgent) *Party {
+	return newParty(details, newCodeListElement("NFY", iataCoreCodeListReference, iataCoreCodeListVersion))
 }
 
 type LogisticsAgent struct {
-	Name        string  `json:"cargo:name,omitempty"`
-	ContactRole *string `json:"cargo:contactRole,omitempty"`
-	Type        string  `json:"@type"`
+	Name             string             `json:"cargo:name,omitempty"`
+	ContactRole      *string            `json:"cargo:contactRole,omitempty"`
+	ContactDetails   []*ContactDetail   `json:"cargo:contactDetails,omitempty"`
+	BasedAtLocation  *Location          `json:"cargo:basedAtLocation,omitempty"`
+	OtherIdentifiers []*OtherIdentifier `json:"cargo:otherIdentifiers,omitempty"`
+	// AirlineCode and AirlinePrefix are set for carriers.
+	AirlineCode   string `json:"cargo:airlineCode,omitempty"`
+	AirlinePrefix string `json:"cargo:airlinePrefix,omitempty"`
+	Type          string `json:"@type"`
 }
 
 type Party struct {
@@ -465,27 +932,25 @@ type Party struct {
 	Type         string           `json:"@type"`
 }
 
-func newShipper(shipperName string) *Party {
-	return newParty(
-		shipperName,
-		nil,
-		newCodeListElement("SHP", iataCoreCodeListReference, iataCoreCodeListVersion),
-	)
+func newShipper(details *LogisticsAgent) *Party {
+	return newParty(details, newCodeListElement("SHP", iataCoreCodeListReference, iataCoreCodeListVersion))
 }
 
-func newParty(logisticsAgentName string, contactRole *string, partyRole *CodeListElement) *Party {
+func newParty(details *LogisticsAgent, partyRole *CodeListElement) *Party {
 	return &Party{
-		PartyDetails: newLogisticsAgent(logisticsAgentName, contactRole),
+		PartyDetails: details,
 		PartyRole:    partyRole,
 		Type:         "cargo:Party",
 	}
 }
 
-func newLogisticsAgent(name string, contactRole *string) *LogisticsAgent {
+func newLogisticsAgent(name string, contactDetails []*ContactDetail, basedAtLocation *Location, taxIdentifiers []*OtherIdentifier) *LogisticsAgent {
 	return &LogisticsAgent{
-		Name:        name,
-		ContactRole: contactRole,
-		Type:        "cargo:LogisticsAgent",
+		Name:             name,
+		ContactDetails:   contactDetails,
+		BasedAtLocation:  basedAtLocation,
+		OtherIdentifiers: taxIdentifiers,
+		Type:             "cargo:LogisticsAgent",
 	}
 }
 
@@ -524,9 +989,6 @@ const (
 	hsCodeListReference = "www.tariffnumber.com"
 	hsCodeListVersion   = "2024"
 
-	currencyCodeListReference = "https://vocabulary.uncefact.org/RevisedCurrencyCode"
-
-	pieceUnitCode              = "H87"
 	pieceUnitCodeListReference = "https://docs.peppol.eu/poacc/billing/3.0/codelist/UNECERec20/"
 	pieceUnitCodeListVersion   = "Revision 11e"
 
@@ -554,42 +1016,6 @@ func newHouseWaybill() *Waybill {
 	}
 }
 
-func sendWaybill(waybill *Waybill) (string, error) {
-	body, err := json.Marshal(waybill)
-	if err != nil {
-		return "", err
-	}
-
-	logisticsObjectEndpoint := "http://your_ne_one_server_here/logistics-objects"
-
-	req, err := http.NewRequest(http.MethodPost, logisticsObjectEndpoint, bytes.NewReader(body))
-	if err != nil {
-		return "", err
-	}
-
-	auth := "Bearer your_token_here"
-
-	req.Header = map[string][]string{
-		"Accept":        {"application/ld+json"},
-		"Authorization": {auth},
-		"Content-Type":  {"application/ld+json"},
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	if resp.StatusCode >= http.StatusBadRequest {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
-		}
-		return "", fmt.Errorf("Status %s, Body: %s", resp.Status, string(body))
-	}
-
-	return resp.Header.Get("Location"), nil
-}
-
 type HouseWaybillNumber string
 
 type CodeListElement struct {
@@ -624,7 +1050,9 @@ type Waybill struct {
 
 	// JSON-LD stuff
 	Context *Context `json:"@context,omitempty"`
-	Type    string   `json:"@type"`
+	// ID is set when the waybill references a logistics object on the server.
+	ID   string `json:"@id,omitempty"`
+	Type string `json:"@type"`
 }
 
 func (w *Waybill) MarshalJSON() ([]byte, error) {
@@ -649,6 +1077,8 @@ func (w *Waybill) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aux)
 }
 
+const cargoNamespace = "https://onerecord.iata.org/ns/cargo#"
+
 type Context struct {
 	Cargo string `json:"cargo,omitempty"`
 }
@@ -656,7 +1086,7 @@ type Context struct {
 func NewMasterWaybill() *Waybill {
 	return &Waybill{
 		Context: &Context{
-			Cargo: "https://onerecord.iata.org/ns/cargo#",
+			Cargo: cargoNamespace,
 		},
 		Type:        "cargo:Waybill",
 		WaybillType: WaybillTypeMaster,
@@ -664,14 +1094,16 @@ func NewMasterWaybill() *Waybill {
 }
 
 type Shipment struct {
-	Pieces           []*Piece `json:"cargo:pieces,omitempty"`
-	Type             string   `json:"@type"`
-	TotalGrossWeight *Value   `json:"cargo:totalGrossWeight,omitempty"`
+	Pieces                  []*Piece       `json:"cargo:pieces,omitempty"`
+	ID                      string         `json:"@id,omitempty"`
+	Type                    string         `json:"@type"`
+	TotalGrossWeight        *Value         `json:"cargo:totalGrossWeight,omitempty"`
+	DeclaredValueForCustoms *CurrencyValue `json:"cargo:declaredValueForCustoms,omitempty"`
 }
 
 type Value struct {
 	Type           string           `json:"@type"`
-	NumericalValue string           `json:"cargo:numericalValue,omitempty"`
+	NumericalValue double           `json:"cargo:numericalValue"`
 	Unit           *CodeListElement `json:"cargo:unit,omitempty"` // TODO: some CodeListElement units on the ne one server string may work
 }
 
@@ -693,6 +1125,7 @@ type Product struct {
 	OtherIdentifiers []*OtherIdentifier `json:"cargo:otherIdentifiers,omitempty"`
 	HsCode           *CodeListElement   `json:"cargo:hsCode,omitempty"`
 	HsType           string             `json:"cargo:hsType,omitempty"`
+	ID               string             `json:"@id,omitempty"`
 	Type             string             `json:"@type"`
 }
 
@@ -715,38 +1148,61 @@ func newHsCode(hsCode string) *CodeListElement {
 }
 
 type Item struct {
-	OfProduct    *Product `json:"cargo:ofProduct,omitempty"`
-	ItemQuantity *Value   `json:"cargo:itemQuantity,omitempty"`
-	UnitPrice    *Value   `json:"cargo:unitPrice,omitempty"`
-	Type         string   `json:"@type"`
+	OfProduct    *Product       `json:"cargo:ofProduct,omitempty"`
+	ItemQuantity *Value         `json:"cargo:itemQuantity,omitempty"`
+	UnitPrice    *CurrencyValue `json:"cargo:unitPrice,omitempty"`
+	ID           string         `json:"@id,omitempty"`
+	Type         string         `json:"@type"`
 }
 
-func newItem(skuNumber, hsCode, itemQuantity, itemPrice, currency string) *Item {
+func newItem(skuNumber, hsCode string, itemQuantity *Value, unitPrice *CurrencyValue) *Item {
 	return &Item{
 		OfProduct:    NewProduct(skuNumber, hsCode),
-		ItemQuantity: newValue(itemQuantity, newPieceUnit()),
-		UnitPrice:    newValue(itemPrice, newCodeListElement(currency, currencyCodeListReference, "")),
+		ItemQuantity: itemQuantity,
+		UnitPrice:    unitPrice,
 		Type:         "cargo:Item",
 	}
 }
 
-func newValue(numericalValue string, unitCodeList *CodeListElement) *Value {
+// CurrencyValue is an amount of money, CurrencyUnit is the ISO 4217 code of the currency.
+type CurrencyValue struct {
+	Type           string `json:"@type"`
+	NumericalValue double `json:"cargo:numericalValue"`
+	CurrencyUnit   string `json:"cargo:currencyUnit,omitempty"`
+}
+
+func newCurrencyValue(amount float64, currency string) *CurrencyValue {
+	return &CurrencyValue{
+		Type:           "cargo:CurrencyValue",
+		NumericalValue: double(amount),
+		CurrencyUnit:   strings.ToUpper(strings.TrimSpace(currency)),
+	}
+}
+
+// newUnitPrice returns the price of an item or nil if the price is empty or not a number.
+func newUnitPrice(price, currency, decimalSeparator string) *CurrencyValue {
+	amount, ok := parsePrice(price, decimalSeparator)
+	if !ok {
+		return nil
+	}
+	return newCurrencyValue(amount, currency)
+}
+
+func newValue(numericalValue float64, unitCodeList *CodeListElement) *Value {
 	return &Value{
 		Type:           "cargo:Value",
-		NumericalValue: numericalValue,
+		NumericalValue: double(numericalValue),
 		Unit:           unitCodeList,
 	}
 }
 
-func newPieceUnit() *CodeListElement {
-	return newCodeListElement(pieceUnitCode, pieceUnitCodeListReference, pieceUnitCodeListVersion)
-}
-
 type Piece struct {
+	ID               string             `json:"@id,omitempty"`
 	Type             string             `json:"@type"`
 	ContainedItems   []*Item            `json:"cargo:containedItems,omitempty"`
 	OtherIdentifiers []*OtherIdentifier `json:"cargo:otherIdentifiers,omitempty"`
 	GoodsDescription string             `json:"cargo:goodsDescription,omitempty"`
+	GrossWeight      *Value             `json:"cargo:grossWeight,omitempty"`
 }
 
 func newPiece(items []*Item, boxNumber, goodsDescription string) *Piece {
@@ -758,6 +1214,20 @@ func newPiece(items []*Item, boxNumber, goodsDescription string) *Piece {
 	}
 }
 
+// addItem packs another item into the piece, different goods descriptions of the items are listed comma separated.
+func (p *Piece) addItem(item *Item, goodsDescription string) {
+	p.ContainedItems = append(p.ContainedItems, item)
+	if goodsDescription == "" {
+		return
+	}
+	descriptions := strings.Split(p.GoodsDescription, ", ")
+	if p.GoodsDescription == "" {
+		p.GoodsDescription = goodsDescription
+	} else if !slices.Contains(descriptions, goodsDescription) {
+		p.GoodsDescription += ", " + goodsDescription
+	}
+}
+
 type WaybillType string
 
 func (t *WaybillType) UnmarshalJSON(data []byte) error {
@@ -784,169 +1254,41 @@ const (
 	WaybillTypeDirect WaybillType = "DIRECT"
 )
 
-func SanitizeMawb(number string) string {
-	sanitizedMawb := strings.ReplaceAll(number, "-", "")
-	sanitizedMawb = strings.ReplaceAll(sanitizedMawb, " ", "")
-	if utf8.RuneCountInString(sanitizedMawb) < 11 {
-		sanitizedMawb = fmt.Sprintf("%011s", sanitizedMawb)
-	}
-	return sanitizedMawb
+// FullWaybillNumber returns the waybill number with prefix, e.g. 020-12345675.
+func (w *Waybill) FullWaybillNumber() string {
+	return w.WaybillPrefix + "-" + w.WaybillNumber
 }
 
-func SplitMawb(number string) (string, string) {
-	return number[:3], number[3:]
-}
+// maxColumns is the number of columns of a spreadsheet, the last column is XFD.
+const maxColumns = 16384
 
-func headersToMapping(headers []string) *SchemaSuggestion {
-	// AI magic here
-	return &SchemaSuggestion{
-		Schema: Schema{
-			MasterWaybillNumber: ColumnMapping{
-				Title:       "Master Waybill Number",
-				Content:     "Filename",
-				UseFilename: Ptr(true),
-			},
-			HouseWaybillNumber: ColumnMapping{
-				Title:   "House Waybill Number",
-				Content: "$A:TRACKING NO.",
-				Column:  Ptr(AlphaToIndex("A")),
-			},
-			ShippingReference: ColumnMapping{
-				Title:   "HAWB Shipping Reference Number",
-				Content: "$B:CUSTOMER REF",
-				Column:  Ptr(AlphaToIndex("B")),
-			},
-			BoxNumber: ColumnMapping{
-				Title:   "Box Number",
-				Content: "$C:Box Number",
-				Column:  Ptr(AlphaToIndex("C")),
-			},
-			ShipperName: ColumnMapping{
-				Title:   "Shipper Name",
-				Content: "$D:SENDER NAME",
-				Column:  Ptr(AlphaToIndex("D")),
-			},
-			ShipperAddressLine1: ColumnMapping{
-				Title:   "Shipper Address Line 1",
-				Content: "$E:SHIPPER ADD 1",
-				Column:  Ptr(AlphaToIndex("E")),
-			},
-			ShipperAddressLine2: ColumnMapping{
-				Title:   "Shipper Address Line 2",
-				Content: "$F:SHIPPER ADD 2",
-				Column:  Ptr(AlphaToIndex("F")),
-			},
-			ShipperAddressLine3: ColumnMapping{
-				Title:   "Shipper Address Line 3",
-				Content: "$G:SHIPPER ADD 3",
-				Column:  Ptr(AlphaToIndex("G")),
-			},
-			ShipperCity: ColumnMapping{
-				Title:   "Shipper City",
-				Content: "$H:SENDER CITY",
-				Column:  Ptr(AlphaToIndex("H")),
-			},
-			ShipperState: ColumnMapping{
-				Title:   "Shipper State/County/Province",
-				Content: "$I:Ship State",
-				Column:  Ptr(AlphaToIndex("I")),
-			},
-			ShipperPostcode: ColumnMapping{
-				Title:   "Shipper Address Line 5",
-				Content: "$J:SENDER POSTCODE",
-				Column:  Ptr(AlphaToIndex("J")),
-			},
-			ShipperCountry: ColumnMapping{
-				Title:   "Shipper Country",
-				Content: "$K:SENDER COUNTRY",
-				Column:  Ptr(AlphaToIndex("K")),
-			},
-			RecipientName: ColumnMapping{
-				Title:   "Recipient Name",
-				Content: "$L:RECEIPIENT NAME",
-				Column:  Ptr(AlphaToIndex("L")),
-			},
-			RecipientAddressLine1: ColumnMapping{
-				Title:   "Recipient Address Line 1",
-				Content: "$M:RECEIPIENT ADD 1",
-				Column:  Ptr(AlphaToIndex("M")),
-			},
-			RecipientAddressLine2: ColumnMapping{
-				Title:   "Recipient Address Line 2",
-				Content: "$N:RECEIPIENT ADD 2",
-				Column:  Ptr(AlphaToIndex("N")),
-			},
-			RecipientAddressLine3: ColumnMapping{
-				Title:   "Recipient Address Line 3",
-				Content: "$O:RECEIPIENT ADD 3",
-				Column:  Ptr(AlphaToIndex("O")),
-			},
-			RecipientCity: ColumnMapping{
-				Title:   "Recipient City",
-				Content: "$P:RECEIPIENT CITY",
-				Column:  Ptr(AlphaToIndex("P")),
-			},
-			RecipientState: ColumnMapping{
-				Title:   "Recipient State/County/Province",
-				Content: "$Q:RECEIPIENT COUNTY",
-				Column:  Ptr(AlphaToIndex("Q")),
-			},
-			RecipientPostcode: ColumnMapping{
-				Title:   "Recipient Address Line 5",
-				Content: "$R:RECEIPIENT POSTCODE",
-				Column:  Ptr(AlphaToIndex("R")),
-			},
-			RecipientCounty: ColumnMapping{
-				Title:   "Recipient County",
-				Content: "$Q:RECEIPIENT COUNTY",
-				Column:  Ptr(AlphaToIndex("Q")),
-			},
-			TotalShipmentGrossWeight: ColumnMapping{
-				Title:   "Total Shipment Gross Weight",
-				Content: "$U:GROSS WEIGHT (KG)",
-				Column:  Ptr(AlphaToIndex("U")),
-			},
-			ItemUnitPriceConcurrency: ColumnMapping{
-				Title:   "Item Unit Price Currency",
-				Content: "$W:Currency",
-				Column:  Ptr(AlphaToIndex("W")),
-			},
-			ProductSKU: ColumnMapping{
-				Title:   "Product SKU",
-				Content: "$X:SKU NUMBER",
-				Column:  Ptr(AlphaToIndex("X")),
-			},
-			ShipmentGoodsDescription: ColumnMapping{
-				Title:   "Shipment Goods Description",
-				Content: "$Y:ITEM DESCRIPTION1",
-				Column:  Ptr(AlphaToIndex("Y")),
-			},
-			ProductHSCode: ColumnMapping{
-				Title:   "Product HS Code",
-				Content: "$Z:ITEM HS CODE",
-				Column:  Ptr(AlphaToIndex("Z")),
-			},
-			ItemQuantity: ColumnMapping{
-				Title:   "Item Quantity",
-				Content: "$AA:ITEM QUANTITY",
-				Column:  Ptr(AlphaToIndex("AA")),
-			},
-			ItemPrice: ColumnMapping{
-				Title:   "Item Price",
-				Content: "$AB:UNIT VALUE",
-				Column:  Ptr(AlphaToIndex("AB")),
-			},
-		},
-		Headers: headers,
+// AlphaToIndex converts spreadsheet column letters such as "A", "Z", "AA", "BA" or "AAA" to a 0-based index.
+func AlphaToIndex(b string) (int, error) {
+	letters := strings.ToUpper(strings.TrimSpace(b))
+	if letters == "" {
+		return 0, errors.New("empty column letters")
 	}
-}
 
-func AlphaToIndex(b string) int {
 	index := 0
-	for i, r := range b {
-		index += int(r) - int('A') + i*26
+	for _, r := range letters {
+		if r < 'A' || r > 'Z' {
+			return 0, fmt.Errorf("invalid column letters %q", b)
+		}
+		index = index*26 + int(r-'A') + 1
+		if index > maxColumns {
+			return 0, fmt.Errorf("column %q is out of range", b)
+		}
+	}
+	return index - 1, nil
+}
+
+func IndexToAlpha(index int) string {
+	var letters []byte
+	for index >= 0 {
+		letters = append([]byte{byte('A' + index%26)}, letters...)
+		index = index/26 - 1
 	}
-	return index
+	return string(letters)
 }
 
 func Ptr[T any](v T) *T {
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestAlphaToIndex(t *testing.T) {
+	tests := []struct {
+		letters string
+		want    int
+		ok      bool
+	}{
+		{"A", 0, true},
+		{"Z", 25, true},
+		{"AA", 26, true},
+		{"AZ", 51, true},
+		{"BA", 52, true},
+		{"ZZ", 701, true},
+		{"AAA", 702, true},
+		{"XFD", 16383, true},
+		{"b", 1, true},
+		{" c ", 2, true},
+		{"XFE", 0, false},
+		{"AAAA", 0, false},
+		{"", 0, false},
+		{"A1", 0, false},
+		{"Ä", 0, false},
+	}
+	for _, test := range tests {
+		got, err := AlphaToIndex(test.letters)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("AlphaToIndex(%q) = %d, %v, want %d, ok %v", test.letters, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestIndexToAlpha(t *testing.T) {
+	for index := 0; index < maxColumns; index++ {
+		if got, err := AlphaToIndex(IndexToAlpha(index)); err != nil || got != index {
+			t.Fatalf("AlphaToIndex(IndexToAlpha(%d)) = %d, %v", index, got, err)
+		}
+	}
+}
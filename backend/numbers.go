@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numberPattern matches numbers with optional thousands separators such as "1.234,56", "1,234.56", "1 234,56" or
+// "1'234.56".
+var numberPattern = regexp.MustCompile(`^[-+]?\d[\d.,' \x{00a0}\x{202f}]*$`)
+
+// ambiguousNumberPattern matches numbers such as "1,234" or "12.500", which are read differently depending on the
+// decimal separator: 1 to 3 digits other than a lone 0, one separator and exactly 3 digits.
+var ambiguousNumberPattern = regexp.MustCompile(`^[-+]?[1-9]\d{0,2}[.,]\d{3}$`)
+
+// parseNumber reads a number written with either decimal separator. decimalSeparator is "." or "," if the pipeline
+// knows how its manifests are written, the other separator is then only accepted between groups of 3 digits, so
+// with "," "1.234,5" is 1234.5 and "1.5" is rejected. If it is empty, the last separator is the decimal separator
+// unless it occurs more than once, so "1,5" and "1.5" are both 1.5, "1,234.5" is 1234.5 and "1.234.567" is a whole
+// number. Numbers that match ambiguousNumberPattern are rejected then, "0,125" and "1 234,500" are not ambiguous.
+func parseNumber(value, decimalSeparator string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if !numberPattern.MatchString(value) {
+		return 0, false
+	}
+	if decimalSeparator == "" && ambiguousNumberPattern.MatchString(value) {
+		return 0, false
+	}
+	digits := strings.NewReplacer(" ", "", "'", "", "\u00a0", "", "\u202f", "").Replace(value)
+	if decimalSeparator != "" && misplacedSeparator(digits, decimalSeparator) {
+		return 0, false
+	}
+
+	if decimalSeparator == "" {
+		if last := strings.LastIndexAny(digits, ".,"); last >= 0 {
+			decimalSeparator = digits[last : last+1]
+			if strings.Count(digits, decimalSeparator) > 1 {
+				decimalSeparator = ""
+			}
+		}
+	}
+	for _, separator := range []string{".", ","} {
+		if separator != decimalSeparator {
+			digits = strings.ReplaceAll(digits, separator, "")
+		}
+	}
+	if decimalSeparator != "" {
+		if strings.Count(digits, decimalSeparator) > 1 {
+			return 0, false
+		}
+		digits = strings.Replace(digits, decimalSeparator, ".", 1)
+	}
+
+	number, err := strconv.ParseFloat(digits, 64)
+	return number, err == nil
+}
+
+// groupedDigitsPattern matches whole numbers with thousands separators such as "1.234" or "12,345,678".
+var groupedDigitsPattern = regexp.MustCompile(`^[-+]?\d{1,3}([.,]\d{3})+$`)
+
+// misplacedSeparator tells whether the separator other than decimalSeparator is used anywhere else than between
+// groups of 3 digits before the decimal separator, e.g. in "1.5" or "1.23,4" if the decimal separator is ",".
+func misplacedSeparator(digits, decimalSeparator string) bool {
+	grouping := ","
+	if decimalSeparator == "," {
+		grouping = "."
+	}
+	integer, fraction, _ := strings.Cut(digits, decimalSeparator)
+	return strings.Contains(fraction, grouping) ||
+		strings.Contains(integer, grouping) && !groupedDigitsPattern.MatchString(integer)
+}
+
+// containedNumberPattern finds the numbers in a value with unit or currency.
+var containedNumberPattern = regexp.MustCompile(`\d[\d.,]*\d`)
+
+// containedAmbiguousNumberPattern finds an ambiguous number in a value with unit or currency.
+var containedAmbiguousNumberPattern = regexp.MustCompile(`(^|[^\d.,])[1-9]\d{0,2}[.,]\d{3}($|[^\d.,])`)
+
+// numberReason returns why a value that could not be read as a number was rejected: because of an ambiguous
+// separator, or else reason.
+func numberReason(value, decimalSeparator, reason string) string {
+	if decimalSeparator == "" && containedAmbiguousNumberPattern.MatchString(value) {
+		return "ambiguous separator, set the decimal separator of the pipeline"
+	}
+	if decimalSeparator != "" {
+		for _, number := range containedNumberPattern.FindAllString(value, -1) {
+			if misplacedSeparator(number, decimalSeparator) {
+				return fmt.Sprintf("ambiguous separator, the decimal separator of the pipeline is %q", decimalSeparator)
+			}
+		}
+	}
+	return reason
+}
+
+// currencySymbolPattern matches the symbols and codes sellers write next to prices, they are removed before parsing.
+var currencySymbolPattern = regexp.MustCompile(`^([A-Za-z]{3}|[€$£¥])?\s*(.*?)\s*([A-Za-z]{3}|[€$£¥])?$`)
+
+// parsePrice reads a price such as "12,50", "€ 1.234,50" or "1,234.50 USD". The currency is not part of the result,
+// it is read from its own column.
+func parsePrice(value, decimalSeparator string) (float64, bool) {
+	match := currencySymbolPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, false
+	}
+	price, ok := parseNumber(match[2], decimalSeparator)
+	return price, ok && price >= 0
+}
+
+// Units of measurement as UN/ECE Recommendation 20 codes.
+const (
+	unitKilogram = "KGM"
+	unitGram     = "GRM"
+	unitPound    = "LBR"
+	unitOunce    = "ONZ"
+	unitPiece    = "H87"
+)
+
+// kilogramsPerUnit converts weights to kilograms.
+var kilogramsPerUnit = map[string]float64{
+	unitKilogram: 1,
+	unitGram:     0.001,
+	unitPound:    0.45359237,
+	unitOunce:    0.028349523125,
+}
+
+// unitSuffixes maps the units sellers write after numbers to their codes.
+var unitSuffixes = map[string]string{
+	"kg": unitKilogram, "kgs": unitKilogram, "kgm": unitKilogram, "kilo": unitKilogram, "kilos": unitKilogram,
+	"kilogram": unitKilogram, "kilograms": unitKilogram, "公斤": unitKilogram, "千克": unitKilogram,
+	"g": unitGram, "gr": unitGram, "grm": unitGram, "gram": unitGram, "grams": unitGram, "克": unitGram,
+	"lb": unitPound, "lbs": unitPound, "lbr": unitPound, "pound": unitPound, "pounds": unitPound, "磅": unitPound,
+	"oz": unitOunce, "onz": unitOunce, "ounce": unitOunce, "ounces": unitOunce,
+	"pc": unitPiece, "pcs": unitPiece, "pce": unitPiece, "piece": unitPiece, "pieces": unitPiece, "ea": unitPiece,
+	"each": unitPiece, "x": unitPiece, "stk": unitPiece, "stück": unitPiece, "h87": unitPiece, "件": unitPiece, "个": unitPiece,
+}
+
+var measurementPattern = regexp.MustCompile(`^([-+]?\d[\d.,' \x{00a0}\x{202f}]*?)\s*(\pL[\pL.]*)?$`)
+
+// parseMeasurement reads a number with an optional unit suffix such as "1,5 kg" or "0.45lbs" and returns the number
+// and the code of its unit, defaultUnit if there is no suffix.
+func parseMeasurement(value, defaultUnit, decimalSeparator string) (float64, string, bool) {
+	match := measurementPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, "", false
+	}
+	number, ok := parseNumber(match[1], decimalSeparator)
+	if !ok {
+		return 0, "", false
+	}
+	unit := defaultUnit
+	if match[2] != "" {
+		if unit, ok = unitSuffixes[strings.ToLower(strings.TrimRight(match[2], "."))]; !ok {
+			return 0, "", false
+		}
+	}
+	return number, unit, true
+}
+
+// parseWeight reads a weight and converts it to kilograms, defaultUnit applies to weights without unit suffix.
+func parseWeight(value, defaultUnit, decimalSeparator string) (float64, bool) {
+	number, unit, ok := parseMeasurement(value, defaultUnit, decimalSeparator)
+	if !ok || number < 0 {
+		return 0, false
+	}
+	factor, ok := kilogramsPerUnit[unit]
+	if !ok {
+		return 0, false
+	}
+	return roundTo(number*factor, 6), true
+}
+
+// parseQuantity reads a number of items such as "3" or "3 pcs".
+func parseQuantity(value, decimalSeparator string) (float64, bool) {
+	number, unit, ok := parseMeasurement(value, unitPiece, decimalSeparator)
+	return number, ok && unit == unitPiece
+}
+
+// double is a number that is always written with a decimal point, JSON-LD reads 2 as xsd:integer and 2.0 as
+// xsd:double, which is what the ONE Record ontology expects for numerical values.
+type double float64
+
+func (d double) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(d)) || math.IsInf(float64(d), 0) {
+		return nil, fmt.Errorf("unsupported number %v", float64(d))
+	}
+	number := strconv.FormatFloat(float64(d), 'f', -1, 64)
+	if !strings.Contains(number, ".") {
+		number += ".0"
+	}
+	return []byte(number), nil
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// unitCodePattern matches UN/ECE Recommendation 20 codes.
+var unitCodePattern = regexp.MustCompile(`^[A-Z0-9]{2,3}$`)
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		value            string
+		decimalSeparator string
+		want             float64
+		ok               bool
+	}{
+		{"12", "", 12, true},
+		{" -3 ", "", -3, true},
+		{"1.5", "", 1.5, true},
+		{"1,5", "", 1.5, true},
+		{"0,125", "", 0.125, true},
+		{"12,50", "", 12.5, true},
+		{"1,234.56", "", 1234.56, true},
+		{"1.234,56", "", 1234.56, true},
+		{"1.234.567", "", 1234567, true},
+		{"1,234,567.8", "", 1234567.8, true},
+		{"1 234,5", "", 1234.5, true},
+		{"1 234,500", "", 1234.5, true},
+		{"1'234.50", "", 1234.5, true},
+		{"1 234", "", 1234, true},
+		{"1234.567", "", 1234.567, true},
+		// one separator followed by three digits could be either
+		{"1,234", "", 0, false},
+		{"1.000", "", 0, false},
+		{"-12.500", "", 0, false},
+		{"1,234", ",", 1.234, true},
+		{"1,234", ".", 1234, true},
+		{"1.000", ",", 1000, true},
+		{"1.234,5", ",", 1234.5, true},
+		{"1.234.567,89", ",", 1234567.89, true},
+		{"1,234,567.89", ".", 1234567.89, true},
+		{"1 234,5", ",", 1234.5, true},
+		{"1,5", ",", 1.5, true},
+		// the other separator only groups thousands
+		{"1.5", ",", 0, false},
+		{"1,5", ".", 0, false},
+		{"12.34", ",", 0, false},
+		{"1.2345", ",", 0, false},
+		{"1234.567,8", ",", 0, false},
+		{"1,234.5", ",", 0, false},
+		{"1,2,3", ",", 0, false},
+		{"", "", 0, false},
+		{"abc", "", 0, false},
+		{"1.5kg", "", 0, false},
+		{".5", "", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseNumber(test.value, test.decimalSeparator)
+		if ok != test.ok || ok && got != test.want {
+			t.Errorf("parseNumber(%q, %q) = %v, %v, want %v, %v", test.value, test.decimalSeparator, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		ok    bool
+	}{
+		{"12,50", 12.5, true},
+		{"€ 1.234,50", 1234.5, true},
+		{"1,234.50 USD", 1234.5, true},
+		{"$7", 7, true},
+		{"EUR 1,000", 0, false},
+		{"-5", 0, false},
+		{"free", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parsePrice(test.value, "")
+		if ok != test.ok || ok && got != test.want {
+			t.Errorf("parsePrice(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		value            string
+		defaultUnit      string
+		decimalSeparator string
+		want             float64
+		ok               bool
+	}{
+		{"2", unitKilogram, "", 2, true},
+		{"1,5 kg", unitKilogram, "", 1.5, true},
+		{"500g", unitKilogram, "", 0.5, true},
+		{"500", unitGram, "", 0.5, true},
+		{"1 lb", unitKilogram, "", 0.453592, true},
+		{"16 oz.", unitKilogram, "", 0.453592, true},
+		{"2 公斤", unitKilogram, "", 2, true},
+		{"1,234 kg", unitKilogram, "", 0, false},
+		{"1,234 kg", unitKilogram, ",", 1.234, true},
+		{"1,234 kg", unitKilogram, ".", 1234, true},
+		{"-1 kg", unitKilogram, "", 0, false},
+		{"3 pcs", unitKilogram, "", 0, false},
+		{"2 stone", unitKilogram, "", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseWeight(test.value, test.defaultUnit, test.decimalSeparator)
+		if ok != test.ok || ok && got != test.want {
+			t.Errorf("parseWeight(%q, %q, %q) = %v, %v, want %v, %v", test.value, test.defaultUnit, test.decimalSeparator, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		value            string
+		decimalSeparator string
+		want             float64
+		ok               bool
+	}{
+		{"3", "", 3, true},
+		{"3 pcs", "", 3, true},
+		{"3x", "", 3, true},
+		{"10 Stück", "", 10, true},
+		{"1,000 pcs", "", 0, false},
+		{"1,000 pcs", ".", 1000, true},
+		{"1.000", ",", 1000, true},
+		{"3 kg", "", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseQuantity(test.value, test.decimalSeparator)
+		if ok != test.ok || ok && got != test.want {
+			t.Errorf("parseQuantity(%q, %q) = %v, %v, want %v, %v", test.value, test.decimalSeparator, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestNumberReason(t *testing.T) {
+	tests := []struct {
+		value            string
+		decimalSeparator string
+		want             string
+	}{
+		{"1,234 kg", "", "ambiguous separator, set the decimal separator of the pipeline"},
+		{"€ 1.000", "", "ambiguous separator, set the decimal separator of the pipeline"},
+		{"1,234 kg", ",", "not a weight"},
+		{"heavy", "", "not a weight"},
+		{"1,2345 kg", "", "not a weight"},
+		{"1.5 kg", ",", `ambiguous separator, the decimal separator of the pipeline is ","`},
+		{"€ 12,50", ".", `ambiguous separator, the decimal separator of the pipeline is "."`},
+		{"1.234,5 stone", ",", "not a weight"},
+	}
+	for _, test := range tests {
+		if got := numberReason(test.value, test.decimalSeparator, "not a weight"); got != test.want {
+			t.Errorf("numberReason(%q, %q) = %q, want %q", test.value, test.decimalSeparator, got, test.want)
+		}
+	}
+}
+
+func TestDoubleMarshalJSON(t *testing.T) {
+	tests := []struct {
+		value double
+		want  string
+	}{
+		{2, "2.0"},
+		{0, "0.0"},
+		{1.5, "1.5"},
+		{-3, "-3.0"},
+		{0.000001, "0.000001"},
+	}
+	for _, test := range tests {
+		got, err := json.Marshal(test.value)
+		if err != nil || string(got) != test.want {
+			t.Errorf("json.Marshal(double(%v)) = %s, %v, want %s", float64(test.value), got, err, test.want)
+		}
+	}
+}
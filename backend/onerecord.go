@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoOneRecordServer = errors.New("no ONE Record server configured")
+
+// OneRecordClient talks to one ONE Record server and authenticates with a static token or OAuth2.
+type OneRecordClient struct {
+	// Name is the name of the server in the config.
+	Name string
+
+	baseURL    string
+	token      string
+	tokens     *tokenSource
+	httpClient *http.Client
+	// timeout is the deadline of a call, which may fetch a token and send the request twice.
+	timeout time.Duration
+}
+
+func NewOneRecordClient(name string, server *OneRecordServer, httpClient *http.Client) *OneRecordClient {
+	client := &OneRecordClient{
+		Name:       name,
+		baseURL:    strings.TrimSuffix(server.URL, "/"),
+		token:      server.Token,
+		httpClient: httpClient,
+		timeout:    server.timeout(),
+	}
+	if server.OAuth2 != nil {
+		client.tokens = newTokenSource(server.OAuth2, httpClient)
+	}
+	return client
+}
+
+// OneRecordClients holds a client for every configured server.
+type OneRecordClients struct {
+	defaultServer string
+	clients       map[string]*OneRecordClient
+}
+
+// NewOneRecordClients creates the clients with the timeouts of their servers. A single request never takes longer
+// than the timeout, even if the server stops answering halfway through the response.
+func NewOneRecordClients(config *OneRecordConfig) *OneRecordClients {
+	clients := &OneRecordClients{defaultServer: config.DefaultServer, clients: make(map[string]*OneRecordClient)}
+	for name, server := range config.Servers {
+		clients.clients[name] = NewOneRecordClient(name, server, &http.Client{Timeout: server.timeout()})
+	}
+	return clients
+}
+
+// Client returns the client of the named server, or of the default server if name is empty.
+func (c *OneRecordClients) Client(name string) (*OneRecordClient, error) {
+	if name == "" {
+		name = c.defaultServer
+	}
+	client := c.clients[name]
+	if client == nil {
+		if len(c.clients) == 0 {
+			return nil, ErrNoOneRecordServer
+		}
+		return nil, fmt.Errorf("unknown ONE Record server %q", name)
+	}
+	return client, nil
+}
+
+// CreateLogisticsObject posts the object to the server and returns the URL of the created logistics object.
+func (c *OneRecordClient) CreateLogisticsObject(object any) (string, error) {
+	body, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodPost, c.baseURL+"/logistics-objects", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("Location"), nil
+}
+
+// GetLogisticsObject returns the logistics object and its latest revision.
+func (c *OneRecordClient) GetLogisticsObject(uri string) (map[string]any, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, 0, err
+	}
+
+	var object map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return nil, 0, fmt.Errorf("decode logistics object: %w", err)
+	}
+
+	revision := 1
+	if value := resp.Header.Get("Latest-Revision"); value != "" {
+		if revision, err = strconv.Atoi(value); err != nil {
+			return nil, 0, fmt.Errorf("invalid Latest-Revision %q", value)
+		}
+	}
+	return object, revision, nil
+}
+
+// UpdateLogisticsObject sends a change request for the logistics object.
+func (c *OneRecordClient) UpdateLogisticsObject(uri string, change *Change) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodPatch, uri, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StatusError is returned when a server answers with an error status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Status %s, Body: %s", e.Status, e.Body)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+}
+
+// isRetryable tells whether sending the request again may succeed: on network errors, server errors,
+// timeouts, rate limits and conflicts with a concurrent change of the logistics object.
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+			return true
+		}
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// do sends a JSON-LD request. If the server rejects a cached OAuth2 token, a new token is fetched and the
+// request is sent once more.
+func (c *OneRecordClient) do(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
+	resp, err := c.send(ctx, method, endpoint, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.tokens == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+	c.tokens.invalidate()
+	return c.send(ctx, method, endpoint, body)
+}
+
+func (c *OneRecordClient) send(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = map[string][]string{
+		"Accept":       {"application/ld+json"},
+		"Content-Type": {"application/ld+json"},
+	}
+
+	switch {
+	case c.tokens != nil:
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get access token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// tokenExpiryDelta renews tokens a little before they expire, so that they do not expire in flight.
+const tokenExpiryDelta = 30 * time.Second
+
+// tokenSource fetches access tokens with the client credentials grant and caches them until they expire.
+// If the token endpoint also returns a refresh token, it is used to renew the access token.
+type tokenSource struct {
+	config     *OAuth2Config
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu            sync.Mutex
+	accessToken   string
+	expiry        time.Time
+	refreshToken  string
+	refreshExpiry time.Time
+}
+
+func newTokenSource(config *OAuth2Config, httpClient *http.Client) *tokenSource {
+	return &tokenSource{config: config, httpClient: httpClient, now: time.Now}
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (s *tokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.accessToken != "" && (s.expiry.IsZero() || now.Before(s.expiry)) {
+		return s.accessToken, nil
+	}
+
+	if s.refreshToken != "" && (s.refreshExpiry.IsZero() || now.Before(s.refreshExpiry)) {
+		err := s.fetch(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {s.refreshToken}})
+		if err == nil {
+			return s.accessToken, nil
+		}
+		// the refresh token may have been revoked, fall back to the client credentials
+		s.refreshToken = ""
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	if err := s.fetch(ctx, form); err != nil {
+		return "", err
+	}
+	return s.accessToken, nil
+}
+
+// invalidate drops the cached access token, e.g. after the server answered 401.
+func (s *tokenSource) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken = ""
+}
+
+func (s *tokenSource) fetch(ctx context.Context, form url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+
+	issued := s.now()
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil && resp.StatusCode < http.StatusBadRequest {
+		return fmt.Errorf("decode token response: %w", err)
+	}
+	switch {
+	case token.Error != "":
+		return fmt.Errorf("token endpoint: %s: %s", token.Error, token.ErrorDescription)
+	case resp.StatusCode >= http.StatusBadRequest:
+		return fmt.Errorf("token endpoint: %w", &StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	case token.AccessToken == "":
+		return errors.New("token endpoint: no access token in response")
+	case token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer"):
+		return fmt.Errorf("token endpoint: unsupported token type %q", token.TokenType)
+	}
+
+	s.accessToken = token.AccessToken
+	s.expiry = expiresAt(issued, token.ExpiresIn)
+	s.refreshToken = token.RefreshToken
+	s.refreshExpiry = expiresAt(issued, token.RefreshExpiresIn)
+	return nil
+}
+
+// expiresAt returns the zero time if the token endpoint did not tell when the token expires.
+func expiresAt(issued time.Time, expiresIn int) time.Time {
+	if expiresIn <= 0 {
+		return time.Time{}
+	}
+	lifetime := time.Duration(expiresIn) * time.Second
+	return issued.Add(lifetime - min(tokenExpiryDelta, lifetime/2))
+}
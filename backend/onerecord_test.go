@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTokenServer issues the tokens token-1, token-2, ... with the given lifetime and records the grant types.
+func newTokenServer(t *testing.T, expiresIn int, refresh bool) (*httptest.Server, *[]string) {
+	t.Helper()
+	var grants []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "toolkit" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		grants = append(grants, r.PostFormValue("grant_type"))
+		token := map[string]any{
+			"access_token": fmt.Sprintf("token-%d", len(grants)),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		}
+		if refresh {
+			token["refresh_token"] = "refresh"
+			token["refresh_expires_in"] = 1800
+		}
+		json.NewEncoder(w).Encode(token)
+	}))
+	t.Cleanup(server.Close)
+	return server, &grants
+}
+
+func TestTokenSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		refresh   bool
+		// after is the time since the first token was fetched
+		after  time.Duration
+		want   string
+		grants []string
+	}{
+		{"cached", 300, false, 0, "token-1", []string{"client_credentials"}},
+		{"cached until shortly before expiry", 300, false, 269 * time.Second, "token-1", []string{"client_credentials"}},
+		{"renewed before expiry", 300, false, 270 * time.Second, "token-2", []string{"client_credentials", "client_credentials"}},
+		{"expired", 300, false, time.Hour, "token-2", []string{"client_credentials", "client_credentials"}},
+		{"short lifetime", 20, false, 9 * time.Second, "token-1", []string{"client_credentials"}},
+		{"short lifetime renewed at half", 20, false, 10 * time.Second, "token-2", []string{"client_credentials", "client_credentials"}},
+		{"no expiry", 0, false, 24 * time.Hour, "token-1", []string{"client_credentials"}},
+		{"refresh token", 300, true, 270 * time.Second, "token-2", []string{"client_credentials", "refresh_token"}},
+		{"refresh token expired", 300, true, time.Hour, "token-2", []string{"client_credentials", "client_credentials"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, grants := newTokenServer(t, test.expiresIn, test.refresh)
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			tokens := newTokenSource(&OAuth2Config{TokenURL: server.URL, ClientID: "toolkit", ClientSecret: "secret"}, server.Client())
+			tokens.now = func() time.Time { return now }
+
+			if token, err := tokens.Token(context.Background()); err != nil || token != "token-1" {
+				t.Fatalf("got first token %q, %v, want token-1", token, err)
+			}
+			now = now.Add(test.after)
+			token, err := tokens.Token(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != test.want {
+				t.Errorf("got %s after %s, want %s", token, test.after, test.want)
+			}
+			if fmt.Sprint(*grants) != fmt.Sprint(test.grants) {
+				t.Errorf("got grants %v, want %v", *grants, test.grants)
+			}
+		})
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	server, _ := newTokenServer(t, 300, false)
+	tokens := newTokenSource(&OAuth2Config{TokenURL: server.URL, ClientID: "toolkit", ClientSecret: "wrong"}, server.Client())
+	want := "token endpoint: invalid_client: "
+	if _, err := tokens.Token(context.Background()); errorString(err) != want {
+		t.Errorf("got error %q, want %q", errorString(err), want)
+	}
+}
+
+func TestOneRecordClientUnauthorized(t *testing.T) {
+	tests := []struct {
+		name string
+		// accepted is the token the ONE Record server accepts
+		accepted string
+		err      string
+		tokens   int
+		requests int
+	}{
+		{"valid token", "token-1", "", 1, 1},
+		{"token revoked", "token-2", "", 2, 2},
+		{"unauthorized", "none", "Status 401 Unauthorized, Body: ", 2, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer, grants := newTokenServer(t, 300, false)
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if r.Header.Get("Authorization") != "Bearer "+test.accepted {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Location", "http://neone/logistics-objects/1")
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer server.Close()
+
+			client := NewOneRecordClient("neone", &OneRecordServer{
+				URL:    server.URL,
+				OAuth2: &OAuth2Config{TokenURL: tokenServer.URL, ClientID: "toolkit", ClientSecret: "secret"},
+			}, server.Client())
+			_, err := client.CreateLogisticsObject(map[string]any{"@type": "cargo:Waybill"})
+			if got := errorString(err); got != test.err {
+				t.Errorf("got error %q, want %q", got, test.err)
+			}
+			if len(*grants) != test.tokens || int(requests.Load()) != test.requests {
+				t.Errorf("fetched %d tokens and sent %d requests, want %d and %d", len(*grants), requests.Load(), test.tokens, test.requests)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"internal server error", &StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{"bad gateway", &StatusError{StatusCode: http.StatusBadGateway}, true},
+		{"service unavailable", &StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"too many requests", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"request timeout", &StatusError{StatusCode: http.StatusRequestTimeout}, true},
+		{"conflict", &StatusError{StatusCode: http.StatusConflict}, true},
+		{"wrapped", fmt.Errorf("token endpoint: %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), true},
+		{"network error", fmt.Errorf("post: %w", &timeoutError{}), true},
+		{"bad request", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"unauthorized", &StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{"not found", &StatusError{StatusCode: http.StatusNotFound}, false},
+		{"unprocessable", &StatusError{StatusCode: http.StatusUnprocessableEntity}, false},
+		{"other error", errors.New("token endpoint: no access token in response"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isRetryable(test.err); got != test.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
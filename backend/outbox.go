@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultOutboxDir = "outbox"
+
+	outboxPollInterval = 5 * time.Second
+	outboxBaseDelay    = 10 * time.Second
+	outboxMaxDelay     = time.Hour
+	// outboxMaxAttempts moves a submission to the dead letters after about a day of retries.
+	outboxMaxAttempts = 30
+)
+
+var (
+	ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+	ErrNotDeadLetter       = errors.New("outbox entry is not a dead letter")
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxDead    OutboxStatus = "dead"
+	OutboxDone    OutboxStatus = "done"
+	// OutboxSuperseded entries are not retried because the manifest was uploaded again.
+	OutboxSuperseded OutboxStatus = "superseded"
+)
+
+// OutboxEntry is a submission to a ONE Record server that failed and is retried in the background.
+type OutboxEntry struct {
+	ID            string           `json:"id"`
+	Status        OutboxStatus     `json:"status"`
+	Pipeline      string           `json:"pipeline"`
+	Server        string           `json:"server"`
+	MasterWaybill string           `json:"masterWaybill"`
+	Attempts      int              `json:"attempts"`
+	LastError     string           `json:"lastError,omitempty"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	UpdatedAt     time.Time        `json:"updatedAt"`
+	NextAttemptAt *time.Time       `json:"nextAttemptAt,omitempty"`
+	Result        *PublishResult   `json:"result,omitempty"`
+	Objects       *PublishedObject `json:"objects,omitempty"`
+}
+
+// Outbox persists failed submissions as <dir>/<id>.json and retries them with exponential backoff.
+// Permanent errors and submissions that keep failing become dead letters, they are retried only when requeued.
+type Outbox struct {
+	dir          string
+	clients      *OneRecordClients
+	publications *PublicationStore
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// openOutbox opens the outbox in the directory given by OUTBOX_DIR.
+func openOutbox(clients *OneRecordClients, publications *PublicationStore) (*Outbox, error) {
+	dir := os.Getenv("OUTBOX_DIR")
+	if dir == "" {
+		dir = defaultOutboxDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Outbox{dir: dir, clients: clients, publications: publications, wake: make(chan struct{}, 1)}, nil
+}
+
+func (o *Outbox) filename(id string) string {
+	return filepath.Join(o.dir, id+".json")
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Enqueue stores a submission that failed with err. It is retried later if the error is retryable,
+// otherwise it becomes a dead letter right away.
+func (o *Outbox) Enqueue(pipeline, server, masterWaybill string, objects *PublishedObject, err error) (*OutboxEntry, error) {
+	id, idErr := newID()
+	if idErr != nil {
+		return nil, idErr
+	}
+
+	now := time.Now().UTC()
+	entry := &OutboxEntry{
+		ID:            id,
+		Pipeline:      pipeline,
+		Server:        server,
+		MasterWaybill: masterWaybill,
+		CreatedAt:     now,
+		Objects:       objects,
+	}
+	entry.failed(err, now)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.write(entry); err != nil {
+		return nil, err
+	}
+	o.notify()
+	return entry, nil
+}
+
+// failed records a failed attempt and schedules the next one.
+func (e *OutboxEntry) failed(err error, now time.Time) {
+	e.Attempts++
+	e.LastError = err.Error()
+	e.UpdatedAt = now
+	if !isRetryable(err) || e.Attempts >= outboxMaxAttempts {
+		e.Status = OutboxDead
+		e.NextAttemptAt = nil
+		return
+	}
+	e.Status = OutboxPending
+	e.NextAttemptAt = Ptr(now.Add(backoff(e.Attempts)))
+}
+
+// backoff doubles the delay with every attempt and picks a random delay in the upper half, so that
+// submissions that failed together are not retried together.
+func backoff(attempts int) time.Duration {
+	delay := outboxMaxDelay
+	if attempts < 20 {
+		delay = min(outboxBaseDelay<<(attempts-1), outboxMaxDelay)
+	}
+	return delay/2 + mathrand.N(delay/2+1)
+}
+
+// List returns the entries with the given status, or all entries if status is empty, oldest first.
+// The logistics objects are left out.
+func (o *Outbox) List(status OutboxStatus) ([]*OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.readAll()
+	if err != nil {
+		return nil, err
+	}
+	listed := make([]*OutboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		if status == "" || entry.Status == status {
+			entry.Objects = nil
+			listed = append(listed, entry)
+		}
+	}
+	return listed, nil
+}
+
+func (o *Outbox) Get(id string) (*OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.read(id)
+}
+
+// Requeue schedules a dead letter for an immediate retry.
+func (o *Outbox) Requeue(id string) (*OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, err := o.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status != OutboxDead {
+		return nil, ErrNotDeadLetter
+	}
+	now := time.Now().UTC()
+	entry.Status = OutboxPending
+	entry.Attempts = 0
+	entry.UpdatedAt = now
+	entry.NextAttemptAt = &now
+	if err := o.write(entry); err != nil {
+		return nil, err
+	}
+	o.notify()
+	entry.Objects = nil
+	return entry, nil
+}
+
+// Supersede stops retrying older submissions of a master waybill, a later upload must not be overwritten by them.
+func (o *Outbox) Supersede(server, masterWaybill string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.readAll()
+	if err != nil {
+		log.Err(err).Msg("read outbox")
+		return
+	}
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		if entry.Server != server || entry.MasterWaybill != masterWaybill {
+			continue
+		}
+		if entry.Status != OutboxPending && entry.Status != OutboxDead {
+			continue
+		}
+		entry.Status = OutboxSuperseded
+		entry.UpdatedAt = now
+		entry.NextAttemptAt = nil
+		entry.Objects = nil
+		if err := o.write(entry); err != nil {
+			log.Err(err).Str("id", entry.ID).Msg("write outbox entry")
+		}
+	}
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run retries due submissions until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		o.retryDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+func (o *Outbox) retryDue() {
+	o.mu.Lock()
+	entries, err := o.readAll()
+	o.mu.Unlock()
+	if err != nil {
+		log.Err(err).Msg("read outbox")
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Status != OutboxPending || entry.NextAttemptAt == nil || entry.NextAttemptAt.After(now) {
+			continue
+		}
+		o.retry(entry)
+	}
+}
+
+// retry publishes a due entry of retryDue. The entry is read before the lock of its publication is taken, so it is
+// read again under the lock: a newer upload of the master waybill may have superseded it meanwhile.
+func (o *Outbox) retry(entry *OutboxEntry) {
+	unlock := o.publications.Lock(entry.Server, entry.MasterWaybill)
+	defer unlock()
+	if stored, err := o.Get(entry.ID); err != nil || stored.Status != OutboxPending {
+		return
+	}
+
+	client, err := o.clients.Client(entry.Server)
+	var result *PublishResult
+	if err == nil {
+		result, err = publishLockedWaybill(client, o.publications, entry.MasterWaybill, entry.Objects, nil)
+	}
+
+	now := time.Now().UTC()
+	if err != nil {
+		entry.failed(err, now)
+		log.Err(err).Str("id", entry.ID).Int("attempts", entry.Attempts).Str("status", string(entry.Status)).Msg("retry submission")
+	} else {
+		entry.Status = OutboxDone
+		entry.UpdatedAt = now
+		entry.NextAttemptAt = nil
+		entry.LastError = ""
+		entry.Result = result
+		// the publication remembers the objects now
+		entry.Objects = nil
+		log.Info().Str("id", entry.ID).Str("waybill", entry.MasterWaybill).Msg("retried submission")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Supersede does not wait for the publication lock, the entry may have been superseded while it was retried
+	if stored, err := o.read(entry.ID); err == nil && stored.Status != OutboxPending {
+		return
+	}
+	if err := o.write(entry); err != nil {
+		log.Err(err).Str("id", entry.ID).Msg("write outbox entry")
+	}
+}
+
+func (o *Outbox) read(id string) (*OutboxEntry, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, ErrOutboxEntryNotFound
+	}
+	file, err := os.Open(o.filename(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrOutboxEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entry := &OutboxEntry{}
+	if err := json.NewDecoder(file).Decode(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (o *Outbox) readAll() ([]*OutboxEntry, error) {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*OutboxEntry, 0, len(files))
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !ok {
+			continue
+		}
+		entry, err := o.read(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	return entries, nil
+}
+
+func (o *Outbox) write(entry *OutboxEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so readers never see a half written entry
+	tmp := o.filename(entry.ID) + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.filename(entry.ID))
+}
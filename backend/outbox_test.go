@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		min, max time.Duration
+	}{
+		{1, 5 * time.Second, 10 * time.Second},
+		{2, 10 * time.Second, 20 * time.Second},
+		{5, 80 * time.Second, 160 * time.Second},
+		{9, 1280 * time.Second, 2560 * time.Second},
+		{10, 30 * time.Minute, time.Hour},
+		{19, 30 * time.Minute, time.Hour},
+		{outboxMaxAttempts, 30 * time.Minute, time.Hour},
+		{100, 30 * time.Minute, time.Hour},
+	}
+	for _, test := range tests {
+		for range 100 {
+			if delay := backoff(test.attempts); delay < test.min || delay > test.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", test.attempts, delay, test.min, test.max)
+			}
+		}
+	}
+}
+
+func TestOutboxEntryFailed(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		status   OutboxStatus
+	}{
+		{"server error", 0, &StatusError{StatusCode: http.StatusServiceUnavailable}, OutboxPending},
+		{"rate limit", 3, &StatusError{StatusCode: http.StatusTooManyRequests}, OutboxPending},
+		{"conflict", 3, &StatusError{StatusCode: http.StatusConflict}, OutboxPending},
+		{"network error", 0, fmt.Errorf("post: %w", &timeoutError{}), OutboxPending},
+		{"bad request", 0, &StatusError{StatusCode: http.StatusBadRequest}, OutboxDead},
+		{"forbidden", 5, &StatusError{StatusCode: http.StatusForbidden}, OutboxDead},
+		{"other error", 0, errors.New("no ONE Record server"), OutboxDead},
+		{"last attempt", outboxMaxAttempts - 2, &StatusError{StatusCode: http.StatusBadGateway}, OutboxPending},
+		{"too many attempts", outboxMaxAttempts - 1, &StatusError{StatusCode: http.StatusBadGateway}, OutboxDead},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := &OutboxEntry{Status: OutboxPending, Attempts: test.attempts}
+			entry.failed(test.err, now)
+
+			if entry.Status != test.status || entry.Attempts != test.attempts+1 || entry.LastError != test.err.Error() || !entry.UpdatedAt.Equal(now) {
+				t.Errorf("got status %s after %d attempts, last error %q, want %s after %d", entry.Status, entry.Attempts, entry.LastError, test.status, test.attempts+1)
+			}
+			switch {
+			case test.status == OutboxDead && entry.NextAttemptAt != nil:
+				t.Errorf("dead letter is scheduled for %s", entry.NextAttemptAt)
+			case test.status == OutboxPending && (entry.NextAttemptAt == nil || !entry.NextAttemptAt.After(now)):
+				t.Errorf("pending entry is scheduled for %v", entry.NextAttemptAt)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+func newTestOutbox(t *testing.T, serverURL string) *Outbox {
+	t.Helper()
+	clients := NewOneRecordClients(&OneRecordConfig{
+		DefaultServer: "neone",
+		Servers:       map[string]*OneRecordServer{"neone": {URL: serverURL}},
+	})
+	publications := &PublicationStore{dir: t.TempDir(), locks: make(map[string]*sync.Mutex)}
+	return &Outbox{dir: t.TempDir(), clients: clients, publications: publications, wake: make(chan struct{}, 1)}
+}
+
+func TestOutboxSupersede(t *testing.T) {
+	outbox := newTestOutbox(t, "http://127.0.0.1:1")
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable}
+	pending, _ := outbox.Enqueue("temu", "neone", "020-12345675", nil, unavailable)
+	dead, _ := outbox.Enqueue("temu", "neone", "020-12345675", nil, &StatusError{StatusCode: http.StatusBadRequest})
+	otherWaybill, _ := outbox.Enqueue("temu", "neone", "160-12345675", nil, unavailable)
+	otherServer, _ := outbox.Enqueue("temu", "other", "020-12345675", nil, unavailable)
+
+	outbox.Supersede("neone", "020-12345675")
+
+	for _, test := range []struct {
+		entry  *OutboxEntry
+		status OutboxStatus
+	}{
+		{pending, OutboxSuperseded},
+		{dead, OutboxSuperseded},
+		{otherWaybill, OutboxPending},
+		{otherServer, OutboxPending},
+	} {
+		stored, err := outbox.Get(test.entry.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != test.status {
+			t.Errorf("entry of %s on %s is %s, want %s", stored.MasterWaybill, stored.Server, stored.Status, test.status)
+		}
+	}
+}
+
+// TestOutboxRetrySuperseded retries an entry that was read as pending but superseded by a newer upload before the
+// retry got the lock of the publication: nothing must be sent.
+func TestOutboxRetrySuperseded(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	outbox := newTestOutbox(t, server.URL)
+	entry, err := outbox.Enqueue("temu", "neone", "020-12345675", &PublishedObject{}, &StatusError{StatusCode: http.StatusServiceUnavailable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := outbox.Get(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox.Supersede("neone", "020-12345675")
+	outbox.retry(snapshot)
+
+	if n := requests.Load(); n > 0 {
+		t.Errorf("superseded entry sent %d requests", n)
+	}
+	stored, err := outbox.Get(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != OutboxSuperseded || stored.Attempts != 1 {
+		t.Errorf("got status %s after %d attempts, want superseded after 1", stored.Status, stored.Attempts)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+type ContactDetail struct {
+	ContactDetailType string `json:"cargo:contactDetailType,omitempty"`
+	TextualValue      string `json:"cargo:textualValue,omitempty"`
+	Type              string `json:"@type"`
+}
+
+func newContactDetail(contactDetailType, textualValue string) *ContactDetail {
+	return &ContactDetail{
+		ContactDetailType: contactDetailType,
+		TextualValue:      textualValue,
+		Type:              "cargo:ContactDetail",
+	}
+}
+
+// newContactDetails returns the email address and phone number of a party, empty values are left out.
+func newContactDetails(email, phone string) []*ContactDetail {
+	var details []*ContactDetail
+	if email != "" {
+		details = append(details, newContactDetail("EMAIL", email))
+	}
+	if phone != "" {
+		details = append(details, newContactDetail("PHONE", phone))
+	}
+	return details
+}
+
+// Tax identifiers are kept as other identifiers of the party.
+const (
+	taxIdentifierEORI = "EORI"
+	taxIdentifierVAT  = "VAT"
+	taxIdentifierIOSS = "IOSS"
+)
+
+// newTaxIdentifiers returns the EORI, VAT and IOSS numbers of a party, empty values are left out.
+func newTaxIdentifiers(eori, vat, ioss string) []*OtherIdentifier {
+	var identifiers []*OtherIdentifier
+	for _, identifier := range []struct{ kind, value string }{
+		{taxIdentifierEORI, eori},
+		{taxIdentifierVAT, vat},
+		{taxIdentifierIOSS, ioss},
+	} {
+		if value := normalizeTaxIdentifier(identifier.value); value != "" {
+			identifiers = append(identifiers, NewOtherIdentifier(identifier.kind, value))
+		}
+	}
+	return identifiers
+}
+
+// normalizeTaxIdentifier removes the spaces, dots and hyphens sellers use to group the characters of tax numbers.
+func normalizeTaxIdentifier(value string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(strings.TrimSpace(value)))
+}
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	phonePattern = regexp.MustCompile(`^\+?[0-9 ()./-]{5,25}$`)
+	// an EORI number is the country code and up to 15 characters, e.g. DE1234567890123
+	eoriPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{1,15}$`)
+	// a VAT number is the country code and 2 to 13 characters, e.g. DE123456789 or GB123456789
+	vatPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9+*]{2,13}$`)
+	// an IOSS number is IM, the 3 digit number of the member state and a 7 digit serial number
+	iossPattern = regexp.MustCompile(`^IM\d{10}$`)
+)
+
+func countDigits(value string) int {
+	digits := 0
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits
+}
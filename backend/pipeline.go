@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPipelineName = errors.New("invalid pipeline name")
+	ErrPipelineExists      = errors.New("pipeline already exists")
+	ErrPipelineNotFound    = errors.New("pipeline not found")
+	ErrVersionNotFound     = errors.New("pipeline version not found")
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", fieldError.Field, fieldError.Message))
+	}
+	return "invalid pipeline: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func validatePipeline(pipeline *Pipeline) error {
+	validationErr := &ValidationError{}
+
+	if err := validatePipelineName(pipeline.Name); err != nil {
+		validationErr.add("name", "must start with a letter or digit and contain only letters, digits, '-' and '_' (at most %d characters)", maxPipelineNameLength)
+	}
+
+	if pipeline.Mapping == nil {
+		validationErr.add("mapping", "must not be empty")
+		return validationErr
+	}
+
+	validateLayout(pipeline.Mapping.Layout, validationErr)
+	if !pipeline.Mapping.OnInvalidRow.valid() {
+		validationErr.add("onInvalidRow", "must be %q or %q", InvalidRowReject, InvalidRowSkip)
+	}
+	if separator := pipeline.Mapping.DecimalSeparator; separator != "" && separator != "." && separator != "," {
+		validationErr.add("decimalSeparator", "must be %q or %q", ".", ",")
+	}
+	if unit := pipeline.Mapping.WeightUnit; unit != "" && kilogramsPerUnit[unit] == 0 {
+		validationErr.add("weightUnit", "must be %s, %s, %s or %s", unitKilogram, unitGram, unitPound, unitOunce)
+	}
+	if unit := pipeline.Mapping.QuantityUnit; unit != "" && !unitCodePattern.MatchString(unit) {
+		validationErr.add("quantityUnit", "must be a UN/ECE Recommendation 20 code such as %s", unitPiece)
+	}
+	if pipeline.Mapping.Layout != nil && pipeline.Mapping.Layout.NoHeader {
+		for _, field := range schemaFields {
+			mapping := field.Mapping(pipeline.Mapping)
+			if mapping.Header != "" {
+				validationErr.add(field.Key, "header binding requires a header row")
+			}
+			if mapping.Expression != "" {
+				if expression, err := parseExpression(mapping.Expression); err == nil && expression.usesHeaders() {
+					validationErr.add(field.Key, "header reference in expression requires a header row")
+				}
+			}
+		}
+	}
+
+	for _, field := range schemaFields {
+		mapping := field.Mapping(pipeline.Mapping)
+
+		sources := 0
+		if mapping.Column != nil {
+			sources++
+			if *mapping.Column < 0 {
+				validationErr.add(field.Key, "column index %d is negative", *mapping.Column)
+			}
+		}
+		if mapping.Letter != "" {
+			sources++
+			if _, err := AlphaToIndex(mapping.Letter); err != nil {
+				validationErr.add(field.Key, "%s", err)
+			}
+		}
+		if mapping.Header != "" {
+			sources++
+		}
+		if len(mapping.Aliases) > 0 && mapping.Header == "" {
+			validationErr.add(field.Key, "aliases require a header")
+		}
+		if mapping.Constant != nil {
+			sources++
+		}
+		if mapping.UseFilename != nil && *mapping.UseFilename {
+			sources++
+		}
+		if mapping.UseSheetName != nil && *mapping.UseSheetName {
+			sources++
+		}
+		if mapping.Cells != "" {
+			sources++
+			if _, err := parseCellRange(mapping.Cells); err != nil {
+				validationErr.add(field.Key, "%s", err)
+			}
+		}
+		if mapping.Expression != "" {
+			sources++
+			if expression, err := parseExpression(mapping.Expression); err != nil {
+				validationErr.add(field.Key, "invalid expression: %s", err)
+			} else {
+				for _, table := range expression.tables {
+					if _, ok := pipeline.Mapping.Lookups[table.name]; !ok {
+						validationErr.add(field.Key, "invalid expression: no lookup table %q", table.name)
+					}
+				}
+			}
+		}
+		if mapping.Pattern != "" {
+			if _, err := regexp.Compile(mapping.Pattern); err != nil {
+				validationErr.add(field.Key, "invalid pattern: %s", err)
+			}
+		}
+
+		// a template describes the house waybills, only the master waybill is still mapped
+		required := field.Required && (pipeline.Mapping.Template == nil || mapping == &pipeline.Mapping.MasterWaybillNumber)
+		switch {
+		case sources == 0 && required:
+			validationErr.add(field.Key, "required field is not mapped")
+		case sources > 1:
+			validationErr.add(field.Key, "exactly one of column, letter, header, expression, constant, useFilename, useSheetName and cells must be set")
+		}
+	}
+
+	if pipeline.Mapping.Template != nil {
+		if _, err := compileTemplate(pipeline.Mapping.Template, nil, pipeline.Mapping.Lookups); err != nil {
+			validationErr.add("template", "%s", err)
+		}
+	}
+
+	if len(validationErr.Errors) > 0 {
+		return validationErr
+	}
+	return nil
+}
+
+type PipelineAction string
+
+const (
+	PipelineActionCreate   PipelineAction = "create"
+	PipelineActionUpdate   PipelineAction = "update"
+	PipelineActionDelete   PipelineAction = "delete"
+	PipelineActionRollback PipelineAction = "rollback"
+)
+
+type PipelineVersion struct {
+	Version  int            `json:"version"`
+	Action   PipelineAction `json:"action"`
+	SavedAt  time.Time      `json:"savedAt"`
+	Pipeline *Pipeline      `json:"pipeline,omitempty"`
+}
+
+const maxPipelineNameLength = 64
+
+var pipelineNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// validatePipelineName makes sure a name can safely be used as a file name or database key.
+func validatePipelineName(name string) error {
+	if len(name) > maxPipelineNameLength || !pipelineNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidPipelineName, name)
+	}
+	return nil
+}
+
+// savePipeline validates the pipeline and stores it as a new version.
+func savePipeline(store PipelineStore, pipeline *Pipeline, action PipelineAction) (*PipelineVersion, error) {
+	if err := validatePipeline(pipeline); err != nil {
+		return nil, err
+	}
+	return store.Save(pipeline, action)
+}
+
+func rollbackPipeline(store PipelineStore, name string, version int) (*PipelineVersion, error) {
+	previous, err := store.Version(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if previous.Pipeline == nil {
+		return nil, fmt.Errorf("version %d deleted the pipeline: %w", version, ErrVersionNotFound)
+	}
+	return savePipeline(store, previous.Pipeline, PipelineActionRollback)
+}
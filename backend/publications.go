@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPublicationDir = "publications"
+
+var ErrPublicationNotFound = errors.New("publication not found")
+
+// Publication remembers the logistics objects that were created for a master waybill on one ONE Record server,
+// so that uploading the manifest again updates them instead of creating duplicates.
+type Publication struct {
+	Server        string           `json:"server"`
+	MasterWaybill string           `json:"masterWaybill"`
+	PublishedAt   time.Time        `json:"publishedAt"`
+	Root          *PublishedObject `json:"root"`
+}
+
+// PublicationStore keeps every publication as <dir>/<server>/<master waybill>.json.
+type PublicationStore struct {
+	dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// openPublicationStore opens the store in the directory given by PUBLICATION_DIR.
+func openPublicationStore() (*PublicationStore, error) {
+	dir := os.Getenv("PUBLICATION_DIR")
+	if dir == "" {
+		dir = defaultPublicationDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &PublicationStore{dir: dir, locks: make(map[string]*sync.Mutex)}, nil
+}
+
+// escapeFilename makes server names and waybill numbers safe to use as file names.
+func escapeFilename(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped
+}
+
+func (s *PublicationStore) filename(server, masterWaybill string) string {
+	return filepath.Join(s.dir, escapeFilename(server), escapeFilename(masterWaybill)+".json")
+}
+
+// Lock serializes uploads of the same master waybill to the same server. It returns the unlock function.
+func (s *PublicationStore) Lock(server, masterWaybill string) func() {
+	s.mu.Lock()
+	key := s.filename(server, masterWaybill)
+	lock := s.locks[key]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		s.locks[key] = lock
+	}
+	s.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (s *PublicationStore) Get(server, masterWaybill string) (*Publication, error) {
+	file, err := os.Open(s.filename(server, masterWaybill))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrPublicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	publication := &Publication{}
+	if err := json.NewDecoder(file).Decode(publication); err != nil {
+		return nil, err
+	}
+	return publication, nil
+}
+
+func (s *PublicationStore) Save(publication *Publication) error {
+	filename := s.filename(publication.Server, publication.MasterWaybill)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(publication)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so readers never see a half written publication
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxConcurrentPublishes limits the number of house waybills that are published at the same time.
+const maxConcurrentPublishes = 8
+
+// PublishedObject is a logistics object as it is sent to the server. Body holds the JSON-LD of the object
+// without the properties that link other logistics objects, those are kept in Links.
+type PublishedObject struct {
+	URI   string                        `json:"uri,omitempty"`
+	Type  string                        `json:"type"`
+	Body  map[string]any                `json:"body"`
+	Links map[string][]*PublishedObject `json:"links,omitempty"`
+}
+
+func newPublishedObject(object any) (*PublishedObject, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	published := &PublishedObject{}
+	if err := json.Unmarshal(data, &published.Body); err != nil {
+		return nil, err
+	}
+	delete(published.Body, "@context")
+	published.Type, _ = published.Body["@type"].(string)
+	return published, nil
+}
+
+func (o *PublishedObject) link(property string, object *PublishedObject) {
+	if o.Links == nil {
+		o.Links = make(map[string][]*PublishedObject)
+	}
+	o.Links[property] = append(o.Links[property], object)
+}
+
+// key identifies a linked object among the objects linked by the same property of the previous publication.
+func (o *PublishedObject) key(index int) string {
+	if number, ok := o.Body["cargo:waybillNumber"].(string); ok && o.Type == "cargo:Waybill" {
+		return number
+	}
+	return strconv.Itoa(index)
+}
+
+// masterWaybillObjects splits the master waybill into the house Waybill, Shipment, Piece, Item and Product
+// logistics objects.
+func masterWaybillObjects(master *Waybill) (*PublishedObject, error) {
+	body := *master
+	body.HouseWaybills = nil
+	object, err := newPublishedObject(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]HouseWaybillNumber, 0, len(master.HouseWaybills))
+	for number := range master.HouseWaybills {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	for _, number := range numbers {
+		house, err := houseWaybillObjects(number, master.HouseWaybills[number])
+		if err != nil {
+			return nil, err
+		}
+		object.link("cargo:houseWaybills", house)
+	}
+	return object, nil
+}
+
+func (w *Waybill) objects() (*PublishedObject, error) {
+	return masterWaybillObjects(w)
+}
+
+func houseWaybillObjects(number HouseWaybillNumber, house *Waybill) (*PublishedObject, error) {
+	body := *house
+	body.WaybillNumber = string(number)
+	body.Shipment = nil
+	object, err := newPublishedObject(&body)
+	if err != nil {
+		return nil, err
+	}
+	if house.Shipment == nil {
+		return object, nil
+	}
+
+	shipmentBody := *house.Shipment
+	shipmentBody.Pieces = nil
+	shipment, err := newPublishedObject(&shipmentBody)
+	if err != nil {
+		return nil, err
+	}
+	object.link("cargo:shipment", shipment)
+
+	for _, piece := range house.Shipment.Pieces {
+		pieceBody := *piece
+		pieceBody.ContainedItems = nil
+		pieceObject, err := newPublishedObject(&pieceBody)
+		if err != nil {
+			return nil, err
+		}
+		shipment.link("cargo:pieces", pieceObject)
+
+		for _, item := range piece.ContainedItems {
+			itemBody := *item
+			itemBody.OfProduct = nil
+			itemObject, err := newPublishedObject(&itemBody)
+			if err != nil {
+				return nil, err
+			}
+			pieceObject.link("cargo:containedItems", itemObject)
+
+			if item.OfProduct != nil {
+				product, err := newPublishedObject(item.OfProduct)
+				if err != nil {
+					return nil, err
+				}
+				itemObject.link("cargo:ofProduct", product)
+			}
+		}
+	}
+	return object, nil
+}
+
+// publishWaybill publishes the logistics objects of a master waybill and remembers them for the next upload.
+func publishWaybill(client *OneRecordClient, publications *PublicationStore, masterWaybill string, root *PublishedObject, progress *JobProgress) (*PublishResult, error) {
+	unlock := publications.Lock(client.Name, masterWaybill)
+	defer unlock()
+	return publishLockedWaybill(client, publications, masterWaybill, root, progress)
+}
+
+// publishLockedWaybill is publishWaybill for callers that hold the lock of the publication.
+func publishLockedWaybill(client *OneRecordClient, publications *PublicationStore, masterWaybill string, root *PublishedObject, progress *JobProgress) (*PublishResult, error) {
+	previous, err := publications.Get(client.Name, masterWaybill)
+	if err != nil && !errors.Is(err, ErrPublicationNotFound) {
+		return nil, fmt.Errorf("read publication: %w", err)
+	}
+
+	publisher := NewPublisher(client)
+	publisher.progress = progress
+	publication, result, err := publisher.Publish(masterWaybill, root, previous)
+	if saveErr := publications.Save(publication); saveErr != nil {
+		log.Err(saveErr).Str("waybill", masterWaybill).Msg("save publication")
+	}
+	return result, err
+}
+
+// PublishResult holds the URLs of the logistics objects created for a master waybill.
+type PublishResult struct {
+	MasterWaybill string                        `json:"masterWaybill"`
+	HouseWaybills map[HouseWaybillNumber]string `json:"houseWaybills"`
+	// Created and Changed count the logistics objects that were created or updated with a change request.
+	Created int `json:"created"`
+	Changed int `json:"changed"`
+}
+
+// Publisher creates every house Waybill, Shipment, Piece, Item and Product as its own logistics object,
+// from the products up to the master waybill, and links them by @id. Objects that were published before are
+// updated with change requests.
+type Publisher struct {
+	client *OneRecordClient
+
+	mu sync.Mutex
+	// products maps the body of a product to its URL, products are shared by all items that contain them.
+	products map[string]*publishedProduct
+	created  int
+	changed  int
+	// progress counts the published objects of an ingestion job, it may be nil.
+	progress *JobProgress
+}
+
+type publishedProduct struct {
+	once sync.Once
+	uri  string
+	err  error
+}
+
+func NewPublisher(client *OneRecordClient) *Publisher {
+	return &Publisher{client: client, products: make(map[string]*publishedProduct)}
+}
+
+// Publish publishes the logistics objects of a master waybill. If previous is the publication of the same master
+// waybill, only the differences are sent. The returned publication is to be stored even on error, it tells which
+// objects exist.
+func (p *Publisher) Publish(masterWaybill string, root *PublishedObject, previous *Publication) (*Publication, *PublishResult, error) {
+	var previousRoot *PublishedObject
+	if previous != nil {
+		previousRoot = previous.Root
+		p.rememberProducts(previousRoot)
+	}
+
+	err := p.publish(previousRoot, root, true)
+	publication := &Publication{
+		Server:        p.client.Name,
+		MasterWaybill: masterWaybill,
+		PublishedAt:   time.Now().UTC(),
+		Root:          root,
+	}
+
+	result := &PublishResult{
+		MasterWaybill: root.URI,
+		HouseWaybills: make(map[HouseWaybillNumber]string),
+		Created:       p.created,
+		Changed:       p.changed,
+	}
+	for _, house := range root.Links["cargo:houseWaybills"] {
+		if house.URI != "" {
+			result.HouseWaybills[HouseWaybillNumber(house.key(0))] = house.URI
+		}
+	}
+	return publication, result, err
+}
+
+// publish creates the object or, if it was published before, updates it. On success object.URI is set.
+// If an update fails, object is reverted to what the server has.
+func (p *Publisher) publish(previous, object *PublishedObject, concurrent bool) error {
+	if object.Type == "cargo:Product" {
+		return p.publishProduct(object)
+	}
+	if previous != nil && previous.Type != object.Type {
+		previous = nil
+	}
+
+	if err := p.publishLinks(previous, object, concurrent); err != nil {
+		if previous != nil && previous.URI != "" {
+			object.revert(previous)
+		}
+		return err
+	}
+	if previous == nil || previous.URI == "" {
+		return p.create(object)
+	}
+
+	changes, err := diffObjects(previous.Body, object.Body, nil)
+	if err == nil {
+		err = p.update(previous, object, changes)
+	}
+	if errors.Is(err, errUnsupportedChange) {
+		// the parent links the new object instead
+		return p.create(object)
+	}
+	if err != nil {
+		object.revert(previous)
+		return err
+	}
+	object.URI = previous.URI
+	return nil
+}
+
+// revert resets the object to the previously published state after a failed update, so that the next upload
+// tries again. Linked objects that were updated keep their new state.
+func (o *PublishedObject) revert(previous *PublishedObject) {
+	links := make(map[string][]*PublishedObject, len(previous.Links))
+	for property, previousLinked := range previous.Links {
+		current := make(map[string]*PublishedObject)
+		for _, linked := range o.Links[property] {
+			if linked.URI != "" {
+				current[linked.URI] = linked
+			}
+		}
+		for _, linked := range previousLinked {
+			if updated, ok := current[linked.URI]; ok {
+				linked = updated
+			}
+			links[property] = append(links[property], linked)
+		}
+	}
+	o.URI, o.Body, o.Links = previous.URI, previous.Body, links
+}
+
+// publishLinks publishes the linked objects, matching them with the objects linked by the previous publication.
+func (p *Publisher) publishLinks(previous, object *PublishedObject, concurrent bool) error {
+	var tasks []func() error
+	for property, linked := range object.Links {
+		previousLinked := make(map[string]*PublishedObject)
+		if previous != nil {
+			for i, previousObject := range previous.Links[property] {
+				previousLinked[previousObject.key(i)] = previousObject
+			}
+		}
+		for i, linkedObject := range linked {
+			previousObject := previousLinked[linkedObject.key(i)]
+			tasks = append(tasks, func() error {
+				return p.publish(previousObject, linkedObject, false)
+			})
+		}
+	}
+
+	if !concurrent {
+		for _, task := range tasks {
+			if err := task(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		errs     []error
+		inFlight = make(chan struct{}, maxConcurrentPublishes)
+	)
+	for _, task := range tasks {
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(task func() error) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			if err := task(); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(task)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// update sends one change request with the changed properties and links of the object.
+func (p *Publisher) update(previous, object *PublishedObject, changes []propertyChange) error {
+	var linkOperations []*Operation
+	for _, property := range unionLinkProperties(previous, object) {
+		var previousURIs, currentURIs []any
+		for _, linked := range previous.Links[property] {
+			previousURIs = append(previousURIs, linked.URI)
+		}
+		for _, linked := range object.Links[property] {
+			currentURIs = append(currentURIs, linked.URI)
+		}
+		removed, added := diffLiterals(previousURIs, currentURIs)
+		for _, uri := range removed {
+			linkOperations = append(linkOperations, newOperation(operationDelete, previous.URI, property, expandTerm(linkedType(previous, property)), uri.(string)))
+		}
+		for _, uri := range added {
+			linkOperations = append(linkOperations, newOperation(operationAdd, previous.URI, property, expandTerm(linkedType(object, property)), uri.(string)))
+		}
+	}
+	if len(changes) == 0 && len(linkOperations) == 0 {
+		return nil
+	}
+
+	current, revision, err := p.client.GetLogisticsObject(previous.URI)
+	if err != nil {
+		return err
+	}
+	operations, err := changeOperations(previous.URI, current, changes)
+	if err != nil {
+		return err
+	}
+	if err := p.client.UpdateLogisticsObject(previous.URI, newChange(previous.URI, revision, append(operations, linkOperations...))); err != nil {
+		return fmt.Errorf("update %s: %w", previous.URI, err)
+	}
+
+	p.mu.Lock()
+	p.changed++
+	p.mu.Unlock()
+	p.progress.objectPublished()
+	return nil
+}
+
+func unionLinkProperties(a, b *PublishedObject) []string {
+	var properties []string
+	for property := range a.Links {
+		properties = append(properties, property)
+	}
+	for property := range b.Links {
+		if _, ok := a.Links[property]; !ok {
+			properties = append(properties, property)
+		}
+	}
+	sort.Strings(properties)
+	return properties
+}
+
+func linkedType(object *PublishedObject, property string) string {
+	for _, linked := range object.Links[property] {
+		return linked.Type
+	}
+	return ""
+}
+
+// create posts the object, its linked objects must have been published before.
+func (p *Publisher) create(object *PublishedObject) error {
+	document := make(map[string]any, len(object.Body)+len(object.Links)+1)
+	for key, value := range object.Body {
+		document[key] = withDoubleValues(value)
+	}
+	for property, linked := range object.Links {
+		references := make([]*Reference, 0, len(linked))
+		for _, linkedObject := range linked {
+			references = append(references, &Reference{ID: linkedObject.URI, Type: linkedObject.Type})
+		}
+		if property == "cargo:shipment" || property == "cargo:ofProduct" {
+			document[property] = references[0]
+		} else {
+			document[property] = references
+		}
+	}
+	document["@context"] = &Context{Cargo: cargoNamespace}
+
+	uri, err := p.client.CreateLogisticsObject(document)
+	if err != nil {
+		return err
+	}
+	if uri == "" {
+		return errors.New("server did not return the Location of the logistics object")
+	}
+	object.URI = uri
+
+	p.mu.Lock()
+	p.created++
+	p.mu.Unlock()
+	p.progress.objectPublished()
+	return nil
+}
+
+// withDoubleValues returns a copy of a property value in which the numerical values of Value and CurrencyValue
+// objects are doubles again, the body holds them as float64 and would send 2.0 as 2.
+func withDoubleValues(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for key, property := range value {
+			copied[key] = withDoubleValues(property)
+		}
+		if typ := value["@type"]; typ == "cargo:Value" || typ == "cargo:CurrencyValue" {
+			if number, ok := value["cargo:numericalValue"].(float64); ok {
+				copied["cargo:numericalValue"] = double(number)
+			}
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(value))
+		for i, element := range value {
+			copied[i] = withDoubleValues(element)
+		}
+		return copied
+	}
+	return value
+}
+
+// publishProduct creates each distinct product once, houses that are published at the same time wait for it.
+func (p *Publisher) publishProduct(product *PublishedObject) error {
+	key, err := json.Marshal(product.Body)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	entry := p.products[string(key)]
+	if entry == nil {
+		entry = &publishedProduct{}
+		p.products[string(key)] = entry
+	}
+	p.mu.Unlock()
+
+	entry.once.Do(func() {
+		if entry.uri != "" {
+			return
+		}
+		if entry.err = p.create(product); entry.err == nil {
+			entry.uri = product.URI
+		}
+	})
+	product.URI = entry.uri
+	return entry.err
+}
+
+// rememberProducts reuses the products of the previous publication.
+func (p *Publisher) rememberProducts(object *PublishedObject) {
+	if object == nil {
+		return
+	}
+	if object.Type == "cargo:Product" && object.URI != "" {
+		if key, err := json.Marshal(object.Body); err == nil {
+			p.products[string(key)] = &publishedProduct{uri: object.URI}
+		}
+	}
+	for _, linked := range object.Links {
+		for _, linkedObject := range linked {
+			p.rememberProducts(linkedObject)
+		}
+	}
+}
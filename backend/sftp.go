@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPServer lets partners drop manifests into the inbox folders of the pipelines they may upload to. Users see
+// /<pipeline>/inbox to upload, and /<pipeline>/processed and /<pipeline>/failed to download files and results.
+type SFTPServer struct {
+	config  *SFTPConfig
+	store   PipelineStore
+	watcher *FolderWatcher
+}
+
+func NewSFTPServer(config *SFTPConfig, store PipelineStore, watcher *FolderWatcher) *SFTPServer {
+	return &SFTPServer{config: config, store: store, watcher: watcher}
+}
+
+func (s *SFTPServer) ListenAndServe() error {
+	hostKey, err := loadHostKey(s.config.HostKey)
+	if err != nil {
+		return fmt.Errorf("host key: %w", err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("addr", s.config.Listen).Msg("serving SFTP")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn, config)
+	}
+}
+
+// loadHostKey reads the private host key, or generates one on first start.
+func loadHostKey(filename string) (ssh.Signer, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.WriteFile(filename, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Info().Str("file", filename).Msg("generated SFTP host key")
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+func (s *SFTPServer) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	user := s.config.Users[conn.User()]
+	if user == nil || user.Password == "" || subtle.ConstantTimeCompare([]byte(user.Password), password) != 1 {
+		return nil, errors.New("invalid user or password")
+	}
+	return nil, nil
+}
+
+func (s *SFTPServer) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	user := s.config.Users[conn.User()]
+	if user != nil {
+		for _, authorized := range user.AuthorizedKeys {
+			authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorized))
+			if err == nil && bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+		}
+	}
+	return nil, errors.New("invalid user or key")
+}
+
+func (s *SFTPServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Err(err).Str("remote", conn.RemoteAddr().String()).Msg("SFTP handshake")
+		return
+	}
+	defer serverConn.Close()
+	logger := log.With().Str("user", serverConn.User()).Str("remote", conn.RemoteAddr().String()).Logger()
+	logger.Info().Msg("SFTP login")
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			logger.Err(err).Msg("accept SFTP session")
+			continue
+		}
+		go func() {
+			for request := range requests {
+				// the payload of a subsystem request is the length prefixed name of the subsystem
+				ok := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(ok, nil)
+			}
+		}()
+
+		folders := &sftpFolders{server: s, user: s.config.Users[serverConn.User()], name: serverConn.User()}
+		server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: folders, FilePut: folders, FileCmd: folders, FileList: folders})
+		go func() {
+			if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+				logger.Err(err).Msg("SFTP session")
+			}
+			server.Close()
+		}()
+	}
+}
+
+// sftpFolders maps the SFTP requests of one user to the pipeline folders.
+type sftpFolders struct {
+	server *SFTPServer
+	user   *SFTPUser
+	name   string
+}
+
+// sftpPath is a parsed path /<pipeline>/<folder>/<file>, its parts are empty for shorter paths.
+type sftpPath struct {
+	pipeline string
+	folder   string
+	file     string
+}
+
+func (f *sftpFolders) parse(filepath string) (sftpPath, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+filepath), "/"), "/")
+	var p sftpPath
+	switch {
+	case len(parts) > 3:
+		return p, sftp.ErrSSHFxNoSuchFile
+	case len(parts) == 3:
+		p.file = parts[2]
+		fallthrough
+	case len(parts) == 2:
+		p.folder = parts[1]
+		if p.folder != folderInbox && p.folder != folderProcessed && p.folder != folderFailed {
+			return p, sftp.ErrSSHFxNoSuchFile
+		}
+		fallthrough
+	default:
+		p.pipeline = parts[0]
+	}
+	if p.pipeline != "" && !f.allowed(p.pipeline) {
+		return p, sftp.ErrSSHFxNoSuchFile
+	}
+	return p, nil
+}
+
+func (f *sftpFolders) allowed(pipeline string) bool {
+	if len(f.user.Pipelines) > 0 && !slices.Contains(f.user.Pipelines, pipeline) {
+		return false
+	}
+	_, err := f.server.store.Get(pipeline)
+	return err == nil
+}
+
+func (f *sftpFolders) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	p, err := f.parse(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if p.file == "" || p.folder == folderInbox {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	return os.Open(f.server.watcher.pipelineDir(p.pipeline, p.folder, p.file))
+}
+
+func (f *sftpFolders) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	p, err := f.parse(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if p.folder != folderInbox || p.file == "" {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	target, err := f.server.watcher.inboxFile(p.pipeline, p.file)
+	if err != nil {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	// the upload is hidden from the watcher until the client closes the file
+	file, err := os.Create(target + uploadSuffix)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("user", f.name).Str("pipeline", p.pipeline).Str("file", p.file).Msg("SFTP upload")
+	return &sftpUpload{File: file, target: target}, nil
+}
+
+// sftpUpload moves an uploaded file into the inbox when it is closed.
+type sftpUpload struct {
+	*os.File
+	target string
+}
+
+func (u *sftpUpload) Close() error {
+	if err := u.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(u.File.Name(), u.target)
+}
+
+func (f *sftpFolders) Filecmd(request *sftp.Request) error {
+	switch request.Method {
+	case "Setstat":
+		// clients set the modification time after uploads, it does not matter here
+		return nil
+	case "Rename":
+		// clients such as WinSCP upload to a temporary name first
+		from, err := f.parse(request.Filepath)
+		if err != nil {
+			return err
+		}
+		to, err := f.parse(request.Target)
+		if err != nil {
+			return err
+		}
+		if from.folder != folderInbox || to.folder != folderInbox || from.pipeline != to.pipeline || from.file == "" || to.file == "" {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		source, err := f.server.watcher.inboxFile(from.pipeline, from.file)
+		if err != nil {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		target, err := f.server.watcher.inboxFile(to.pipeline, to.file)
+		if err != nil {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return os.Rename(source, target)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (f *sftpFolders) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	p, err := f.parse(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.Method {
+	case "Stat":
+		if p.file == "" {
+			return sftpListing{folderInfo(path.Base(request.Filepath))}, nil
+		}
+		info, err := os.Stat(f.server.watcher.pipelineDir(p.pipeline, p.folder, p.file))
+		if err != nil {
+			return nil, err
+		}
+		return sftpListing{info}, nil
+	case "List":
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+
+	switch {
+	case p.pipeline == "":
+		pipelines, err := f.server.store.List()
+		if err != nil {
+			return nil, err
+		}
+		var listing sftpListing
+		for _, pipeline := range pipelines {
+			if len(f.user.Pipelines) == 0 || slices.Contains(f.user.Pipelines, pipeline.Name) {
+				listing = append(listing, folderInfo(pipeline.Name))
+			}
+		}
+		return listing, nil
+	case p.folder == "":
+		return sftpListing{folderInfo(folderInbox), folderInfo(folderProcessed), folderInfo(folderFailed)}, nil
+	case p.file != "":
+		return nil, os.ErrInvalid
+	}
+
+	entries, err := os.ReadDir(f.server.watcher.pipelineDir(p.pipeline, p.folder))
+	if errors.Is(err, os.ErrNotExist) {
+		return sftpListing{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	listing := make(sftpListing, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			listing = append(listing, info)
+		}
+	}
+	return listing, nil
+}
+
+type sftpListing []os.FileInfo
+
+func (l sftpListing) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// folderInfo describes the folders that only exist for SFTP users, such as the root with the pipelines.
+type folderInfo string
+
+func (f folderInfo) Name() string       { return string(f) }
+func (f folderInfo) Size() int64        { return 0 }
+func (f folderInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (f folderInfo) ModTime() time.Time { return time.Time{} }
+func (f folderInfo) IsDir() bool        { return true }
+func (f folderInfo) Sys() any           { return nil }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// PipelineStore persists pipelines together with the history of every change.
+type PipelineStore interface {
+	List() ([]*Pipeline, error)
+	Get(name string) (*Pipeline, error)
+	// Save stores the pipeline as a new version. Create fails if the pipeline exists, Update if it does not.
+	Save(pipeline *Pipeline, action PipelineAction) (*PipelineVersion, error)
+	Delete(name string) error
+	Versions(name string) ([]*PipelineVersion, error)
+	Version(name string, version int) (*PipelineVersion, error)
+	Close() error
+}
+
+const (
+	PipelineStoreFile = "file"
+	PipelineStoreBolt = "bolt"
+
+	defaultPipelineDatabase = "pipelines.db"
+)
+
+// openPipelineStore opens the store selected by the PIPELINE_STORE and PIPELINE_STORE_PATH environment variables.
+func openPipelineStore() (PipelineStore, error) {
+	kind := os.Getenv("PIPELINE_STORE")
+	path := os.Getenv("PIPELINE_STORE_PATH")
+
+	switch kind {
+	case "", PipelineStoreFile:
+		if path == "" {
+			path = PIPELINE_DIR
+		}
+		return NewFilePipelineStore(path)
+	case PipelineStoreBolt:
+		if path == "" {
+			path = defaultPipelineDatabase
+		}
+		return NewBoltPipelineStore(path)
+	}
+	return nil, fmt.Errorf("unknown pipeline store %q", kind)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pipelinesBucket        = []byte("pipelines")
+	pipelineVersionsBucket = []byte("pipelineVersions")
+)
+
+// BoltPipelineStore keeps pipelines in an embedded bbolt database. Every pipeline has its own nested bucket of versions.
+type BoltPipelineStore struct {
+	db *bolt.DB
+}
+
+func NewBoltPipelineStore(path string) (*BoltPipelineStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(pipelinesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(pipelineVersionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltPipelineStore{db: db}, nil
+}
+
+func (s *BoltPipelineStore) List() ([]*Pipeline, error) {
+	var pipelines []*Pipeline
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pipelinesBucket).ForEach(func(name, value []byte) error {
+			pipeline := &Pipeline{}
+			if err := json.Unmarshal(value, pipeline); err != nil {
+				// one broken pipeline must not hide the others
+				log.Err(err).Str("pipeline", string(name)).Msg("decode pipeline")
+				return nil
+			}
+			pipelines = append(pipelines, pipeline)
+			return nil
+		})
+	})
+	return pipelines, err
+}
+
+func (s *BoltPipelineStore) Get(name string) (*Pipeline, error) {
+	if err := validatePipelineName(name); err != nil {
+		return nil, err
+	}
+
+	pipeline := &Pipeline{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(pipelinesBucket).Get([]byte(name))
+		if value == nil {
+			return ErrPipelineNotFound
+		}
+		return json.Unmarshal(value, pipeline)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+func (s *BoltPipelineStore) Save(pipeline *Pipeline, action PipelineAction) (*PipelineVersion, error) {
+	if err := validatePipelineName(pipeline.Name); err != nil {
+		return nil, err
+	}
+
+	var version *PipelineVersion
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		pipelines := tx.Bucket(pipelinesBucket)
+		exists := pipelines.Get([]byte(pipeline.Name)) != nil
+		switch {
+		case action == PipelineActionCreate && exists:
+			return ErrPipelineExists
+		case action == PipelineActionUpdate && !exists:
+			return ErrPipelineNotFound
+		}
+
+		var err error
+		version, err = appendBoltVersion(tx, pipeline.Name, action, pipeline)
+		if err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(pipeline)
+		if err != nil {
+			return err
+		}
+		return pipelines.Put([]byte(pipeline.Name), body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (s *BoltPipelineStore) Delete(name string) error {
+	if err := validatePipelineName(name); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		pipelines := tx.Bucket(pipelinesBucket)
+		if pipelines.Get([]byte(name)) == nil {
+			return ErrPipelineNotFound
+		}
+		if _, err := appendBoltVersion(tx, name, PipelineActionDelete, nil); err != nil {
+			return err
+		}
+		return pipelines.Delete([]byte(name))
+	})
+}
+
+func (s *BoltPipelineStore) Versions(name string) ([]*PipelineVersion, error) {
+	if err := validatePipelineName(name); err != nil {
+		return nil, err
+	}
+
+	versions := []*PipelineVersion{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pipelineVersionsBucket).Bucket([]byte(name))
+		if bucket == nil {
+			// pipelines created before the history was kept have no versions yet
+			if tx.Bucket(pipelinesBucket).Get([]byte(name)) == nil {
+				return ErrPipelineNotFound
+			}
+			return nil
+		}
+		// keys are big endian, so iteration order is version order
+		return bucket.ForEach(func(_, value []byte) error {
+			version := &PipelineVersion{}
+			if err := json.Unmarshal(value, version); err != nil {
+				return err
+			}
+			versions = append(versions, version)
+			return nil
+		})
+	})
+	return versions, err
+}
+
+func (s *BoltPipelineStore) Version(name string, version int) (*PipelineVersion, error) {
+	if err := validatePipelineName(name); err != nil {
+		return nil, err
+	}
+
+	pipelineVersion := &PipelineVersion{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pipelineVersionsBucket).Bucket([]byte(name))
+		if bucket == nil || version < 1 {
+			return ErrVersionNotFound
+		}
+		value := bucket.Get(versionKey(uint64(version)))
+		if value == nil {
+			return ErrVersionNotFound
+		}
+		return json.Unmarshal(value, pipelineVersion)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pipelineVersion, nil
+}
+
+func (s *BoltPipelineStore) Close() error {
+	return s.db.Close()
+}
+
+func appendBoltVersion(tx *bolt.Tx, name string, action PipelineAction, pipeline *Pipeline) (*PipelineVersion, error) {
+	bucket, err := tx.Bucket(pipelineVersionsBucket).CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	version := &PipelineVersion{
+		Version: int(sequence),
+		Action:  action,
+		SavedAt: time.Now().UTC(),
+	}
+	if pipeline != nil {
+		pipeline.Version = version.Version
+		version.Pipeline = pipeline
+	}
+
+	body, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	return version, bucket.Put(versionKey(sequence), body)
+}
+
+func versionKey(version uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, version)
+	return key
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const PIPELINE_VERSIONS_DIR = "versions"
+
+// FilePipelineStore keeps every pipeline as <dir>/<name>.json and its history in <dir>/versions/<name>/.
+type FilePipelineStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFilePipelineStore(dir string) (*FilePipelineStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, PIPELINE_VERSIONS_DIR), 0755); err != nil {
+		return nil, err
+	}
+	return &FilePipelineStore{dir: dir}, nil
+}
+
+func (s *FilePipelineStore) filename(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+func (s *FilePipelineStore) versionsDir(name string) string {
+	return filepath.Join(s.dir, PIPELINE_VERSIONS_DIR, name)
+}
+
+func (s *FilePipelineStore) List() ([]*Pipeline, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelines := make([]*Pipeline, 0, len(files))
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !ok || validatePipelineName(name) != nil {
+			continue
+		}
+		pipeline, err := s.Get(name)
+		if err != nil {
+			// one broken file must not hide the other pipelines
+			log.Err(err).Str("pipeline", name).Msg("read pipeline")
+			continue
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	return pipelines, nil
+}
+
+func (s *FilePipelineStore) Get(name string) (*Pipeline, error) {
+	if err := validatePipelineName(name); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(s.filename(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrPipelineNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pipeline := &Pipeline{}
+	if err := json.NewDecoder(file).Decode(pipeline); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+func (s *FilePipelineStore) exists(name string) (bool, error) {
+	_, err := os.Stat(s.filename(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *FilePipelineStore) Save(pipeline *Pipeline, action PipelineAction) (*PipelineVersion, error) {
+	if err := validatePipelineName(pipeline.Name); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.exists(pipeline.Name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case action == PipelineActionCreate && exists:
+		return nil, ErrPipelineExists
+	case action == PipelineActionUpdate && !exists:
+		return nil, ErrPipelineNotFound
+	}
+
+	version, err := s.appendVersion(pipeline.Name, action, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.MarshalIndent(pipeline, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// write to a temporary file first so readers never see a half written pipeline
+	tmp := s.filename(pipeline.Name) + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, s.filename(pipeline.Name)); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (s *FilePipelineStore) Delete(name string) error {
+	if err := validatePipelineName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.exists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPipelineNotFound
+	}
+
+	if _, err := s.appendVersion(name, PipelineActionDelete, nil); err != nil {
+		return err
+	}
+	return os.Remove(s.filename(name))
+}
+
+func (s *FilePipelineStore) Versions(name string) ([]*PipelineVersion, error) {
+	if err := validatePipelineName(name); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.versionsDir(name))
+	if errors.Is(err, os.ErrNotExist) {
+		// pipelines created before the history was kept have no versions yet
+		if _, err := s.Get(name); err != nil {
+			return nil, err
+		}
+		return []*PipelineVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*PipelineVersion, 0, len(entries))
+	for _, entry := range entries {
+		number, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		version, err := s.Version(name, number)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+func (s *FilePipelineStore) Version(name string, version int) (*PipelineVersion, error) {
+	if err := validatePipelineName(name); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(s.versionsDir(name), fmt.Sprintf("%06d.json", version)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pipelineVersion := &PipelineVersion{}
+	if err := json.NewDecoder(file).Decode(pipelineVersion); err != nil {
+		return nil, err
+	}
+	return pipelineVersion, nil
+}
+
+func (s *FilePipelineStore) Close() error {
+	return nil
+}
+
+func (s *FilePipelineStore) appendVersion(name string, action PipelineAction, pipeline *Pipeline) (*PipelineVersion, error) {
+	dir := s.versionsDir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	latest := 0
+	for _, entry := range entries {
+		if number, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json")); err == nil && number > latest {
+			latest = number
+		}
+	}
+
+	version := &PipelineVersion{
+		Version: latest + 1,
+		Action:  action,
+		SavedAt: time.Now().UTC(),
+	}
+	if pipeline != nil {
+		pipeline.Version = version.Version
+		version.Pipeline = pipeline
+	}
+
+	body, err := json.MarshalIndent(version, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// O_EXCL makes concurrent writers fail instead of overwriting each other's version
+	file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%06d.json", version.Version)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Write(body); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
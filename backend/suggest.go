@@ -0,0 +1,610 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// minSuggestionConfidence is the score below which a header is not considered a match for a field.
+const minSuggestionConfidence = 0.6
+
+const (
+	defaultSampleRows    = 20
+	maxSampleRows        = 1000
+	maxSuggestionSamples = 5
+)
+
+type schemaField struct {
+	Key      string
+	Title    string
+	Required bool
+	Kind     valueKind
+	Synonyms []string
+	Mapping  func(s *Schema) *ColumnMapping
+}
+
+// schemaFields lists every Schema field together with the header names sellers commonly use for it.
+var schemaFields = []schemaField{
+	{
+		Key:      "waybill",
+		Title:    "Master Waybill Number",
+		Required: true,
+		Kind:     valueKindAWB,
+		Synonyms: []string{
+			"MAWB", "MAWB NO", "MAWB NUMBER", "MASTER AWB", "MASTER WAYBILL", "MASTER WAYBILL NUMBER", "MASTER AIR WAYBILL",
+			"LUFTFRACHTBRIEF", "MASTER LUFTFRACHTBRIEF", "LTA", "LETTRE DE TRANSPORT AERIEN", "总运单号", "主单号", "主运单号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.MasterWaybillNumber },
+	},
+	{
+		Key:      "houseWaybill",
+		Title:    "House Waybill Number",
+		Required: true,
+		Synonyms: []string{
+			"TRACKING NO", "TRACKING NUMBER", "TRACKING", "HAWB", "HAWB NO", "HAWB NUMBER", "HOUSE AWB", "HOUSE WAYBILL",
+			"PARCEL NUMBER", "PARCEL ID", "SENDUNGSNUMMER", "SENDUNGSNR", "NUMERO DE SUIVI", "NUMERO DE SEGUIMIENTO",
+			"TAKIP NO", "运单号", "分运单号", "跟踪号", "物流单号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.HouseWaybillNumber },
+	},
+	{
+		Key:   "shippingReference",
+		Title: "HAWB Shipping Reference Number",
+		Synonyms: []string{
+			"CUSTOMER REF", "CUSTOMER REFERENCE", "REFERENCE", "REF", "ORDER NO", "ORDER NUMBER", "ORDER ID",
+			"SHIPPING REFERENCE", "BESTELLNUMMER", "REFERENZ", "KUNDENREFERENZ", "REFERENCE CLIENT", "订单号", "客户参考号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShippingReference },
+	},
+	{
+		Key:   "boxNumber",
+		Title: "Box Number",
+		Synonyms: []string{
+			"BOX NUMBER", "BOX NO", "BOX", "CARTON NO", "CARTON NUMBER", "BAG NO", "BAG NUMBER", "PACKAGE NO",
+			"KARTON", "KARTONNUMMER", "PAKETNUMMER", "NUMERO DE COLIS", "箱号", "袋号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.BoxNumber },
+	},
+	{
+		Key:      "shipperName",
+		Title:    "Shipper Name",
+		Required: true,
+		Synonyms: []string{
+			"SENDER NAME", "SHIPPER NAME", "SENDER", "SHIPPER", "CONSIGNOR", "CONSIGNOR NAME", "ABSENDER", "ABSENDER NAME",
+			"VERSENDER", "EXPEDITEUR", "REMITENTE", "GONDERICI", "发件人", "寄件人", "发货人",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperName },
+	},
+	{
+		Key:   "shipperAddressLine1",
+		Title: "Shipper Address Line 1",
+		Synonyms: []string{
+			"SHIPPER ADD 1", "SHIPPER ADDRESS 1", "SHIPPER ADDRESS LINE 1", "SENDER ADDRESS 1", "SENDER ADDRESS",
+			"SHIPPER ADDRESS", "ABSENDER ADRESSE", "ABSENDER STRASSE", "发件人地址", "发件人地址1",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperAddressLine1 },
+	},
+	{
+		Key:      "shipperAddressLine2",
+		Title:    "Shipper Address Line 2",
+		Synonyms: []string{"SHIPPER ADD 2", "SHIPPER ADDRESS 2", "SHIPPER ADDRESS LINE 2", "SENDER ADDRESS 2", "ABSENDER ADRESSE 2", "发件人地址2"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.ShipperAddressLine2 },
+	},
+	{
+		Key:      "shipperAddressLine3",
+		Title:    "Shipper Address Line 3",
+		Synonyms: []string{"SHIPPER ADD 3", "SHIPPER ADDRESS 3", "SHIPPER ADDRESS LINE 3", "SENDER ADDRESS 3", "ABSENDER ADRESSE 3", "发件人地址3"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.ShipperAddressLine3 },
+	},
+	{
+		Key:      "shipperCity",
+		Title:    "Shipper City",
+		Synonyms: []string{"SENDER CITY", "SHIPPER CITY", "ABSENDER ORT", "ABSENDER STADT", "VILLE EXPEDITEUR", "发件人城市", "发件城市"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.ShipperCity },
+	},
+	{
+		Key:   "shipperState",
+		Title: "Shipper State/County/Province",
+		Synonyms: []string{
+			"SHIP STATE", "SHIPPER STATE", "SENDER STATE", "SHIPPER PROVINCE", "SENDER PROVINCE", "SHIPPER REGION",
+			"ABSENDER BUNDESLAND", "发件人省份", "发件省份", "发件人州",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperState },
+	},
+	{
+		Key:   "shipperPostcode",
+		Title: "Shipper Address Line 5",
+		Kind:  valueKindPostcode,
+		Synonyms: []string{
+			"SENDER POSTCODE", "SHIPPER POSTCODE", "SENDER ZIP", "SHIPPER ZIP", "SHIPPER POSTAL CODE", "SENDER POSTAL CODE",
+			"ABSENDER PLZ", "ABSENDER POSTLEITZAHL", "发件人邮编", "发件邮编",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperPostcode },
+	},
+	{
+		Key:      "shipperCountry",
+		Title:    "Shipper Country",
+		Required: true,
+		Kind:     valueKindCountry,
+		Synonyms: []string{
+			"SENDER COUNTRY", "SHIPPER COUNTRY", "ORIGIN COUNTRY", "COUNTRY OF ORIGIN", "ABSENDER LAND", "URSPRUNGSLAND",
+			"PAYS EXPEDITEUR", "发件人国家", "发件国家", "始发国",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperCountry },
+	},
+	{
+		Key:   "shipperEmail",
+		Title: "Shipper Email",
+		Kind:  valueKindEmail,
+		Synonyms: []string{
+			"SENDER EMAIL", "SHIPPER EMAIL", "SENDER E-MAIL", "SHIPPER E-MAIL", "SELLER EMAIL", "ABSENDER EMAIL",
+			"ABSENDER E-MAIL", "发件人邮箱", "发件人电子邮件",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperEmail },
+	},
+	{
+		Key:   "shipperPhone",
+		Title: "Shipper Phone",
+		Kind:  valueKindPhone,
+		Synonyms: []string{
+			"SENDER PHONE", "SHIPPER PHONE", "SENDER TEL", "SHIPPER TEL", "SENDER PHONE NUMBER", "SHIPPER PHONE NUMBER",
+			"ABSENDER TELEFON", "发件人电话", "发件人手机",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperPhone },
+	},
+	{
+		Key:      "shipperEori",
+		Title:    "Shipper EORI Number",
+		Kind:     valueKindEORI,
+		Synonyms: []string{"SENDER EORI", "SHIPPER EORI", "EXPORTER EORI", "SELLER EORI", "ABSENDER EORI", "发件人EORI"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.ShipperEORI },
+	},
+	{
+		Key:   "shipperVat",
+		Title: "Shipper VAT Number",
+		Kind:  valueKindVAT,
+		Synonyms: []string{
+			"SENDER VAT", "SHIPPER VAT", "SELLER VAT", "SENDER VAT NUMBER", "SHIPPER VAT NUMBER", "SELLER VAT NUMBER",
+			"ABSENDER UST-ID", "ABSENDER USTID", "发件人税号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipperVAT },
+	},
+	{
+		Key:      "shipperIoss",
+		Title:    "Shipper IOSS Number",
+		Kind:     valueKindIOSS,
+		Synonyms: []string{"IOSS", "IOSS NUMBER", "IOSS NO", "IOSS ID", "SELLER IOSS", "SHIPPER IOSS", "IOSS号"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.ShipperIOSS },
+	},
+	{
+		Key:      "recipientName",
+		Title:    "Recipient Name",
+		Required: true,
+		Synonyms: []string{
+			"RECEIPIENT NAME", "RECIPIENT NAME", "RECIPIENT", "RECEIVER NAME", "RECEIVER", "CONSIGNEE", "CONSIGNEE NAME",
+			"EMPFANGER", "EMPFANGER NAME", "EMPFAENGER", "EMPFAENGER NAME", "DESTINATAIRE", "DESTINATARIO", "ALICI",
+			"收件人", "收件人姓名", "收货人",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientName },
+	},
+	{
+		Key:      "recipientAddressLine1",
+		Title:    "Recipient Address Line 1",
+		Required: true,
+		Synonyms: []string{
+			"RECEIPIENT ADD 1", "RECIPIENT ADD 1", "RECIPIENT ADDRESS 1", "RECIPIENT ADDRESS LINE 1", "RECIPIENT ADDRESS",
+			"CONSIGNEE ADDRESS", "RECEIVER ADDRESS", "EMPFAENGER ADRESSE", "EMPFAENGER STRASSE", "ADRESSE DESTINATAIRE",
+			"收件人地址", "收件人地址1",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientAddressLine1 },
+	},
+	{
+		Key:   "recipientAddressLine2",
+		Title: "Recipient Address Line 2",
+		Synonyms: []string{
+			"RECEIPIENT ADD 2", "RECIPIENT ADD 2", "RECIPIENT ADDRESS 2", "RECIPIENT ADDRESS LINE 2", "CONSIGNEE ADDRESS 2",
+			"EMPFAENGER ADRESSE 2", "收件人地址2",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientAddressLine2 },
+	},
+	{
+		Key:   "recipientAddressLine3",
+		Title: "Recipient Address Line 3",
+		Synonyms: []string{
+			"RECEIPIENT ADD 3", "RECIPIENT ADD 3", "RECIPIENT ADDRESS 3", "RECIPIENT ADDRESS LINE 3", "CONSIGNEE ADDRESS 3",
+			"EMPFAENGER ADRESSE 3", "收件人地址3",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientAddressLine3 },
+	},
+	{
+		Key:      "recipientCity",
+		Title:    "Recipient City",
+		Required: true,
+		Synonyms: []string{
+			"RECEIPIENT CITY", "RECIPIENT CITY", "CONSIGNEE CITY", "RECEIVER CITY", "DESTINATION CITY", "CITY",
+			"EMPFAENGER ORT", "EMPFAENGER STADT", "ORT", "STADT", "VILLE", "CIUDAD", "SEHIR", "收件人城市", "城市",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientCity },
+	},
+	{
+		Key:   "recipientState",
+		Title: "Recipient State/County/Province",
+		Synonyms: []string{
+			"RECIPIENT STATE", "RECEIPIENT STATE", "CONSIGNEE STATE", "RECIPIENT PROVINCE", "RECIPIENT REGION", "STATE",
+			"PROVINCE", "EMPFAENGER BUNDESLAND", "BUNDESLAND", "收件人省份", "省份", "州",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientState },
+	},
+	{
+		Key:      "recipientPostcode",
+		Title:    "Recipient Address Line 5",
+		Required: true,
+		Kind:     valueKindPostcode,
+		Synonyms: []string{
+			"RECEIPIENT POSTCODE", "RECIPIENT POSTCODE", "RECIPIENT ZIP", "CONSIGNEE POSTCODE", "CONSIGNEE ZIP",
+			"POSTCODE", "ZIP", "ZIP CODE", "POSTAL CODE", "EMPFAENGER PLZ", "PLZ", "POSTLEITZAHL", "CODE POSTAL",
+			"CODIGO POSTAL", "POSTA KODU", "收件人邮编", "邮编",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientPostcode },
+	},
+	{
+		Key:      "recipientCounty",
+		Title:    "Recipient County",
+		Synonyms: []string{"RECEIPIENT COUNTY", "RECIPIENT COUNTY", "CONSIGNEE COUNTY", "COUNTY", "LANDKREIS", "KREIS", "收件人县"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.RecipientCounty },
+	},
+	{
+		Key:      "recipientCountry",
+		Title:    "Recipient Country",
+		Required: true,
+		Kind:     valueKindCountry,
+		Synonyms: []string{
+			"RECEIPIENT COUNTRY", "RECIPIENT COUNTRY", "CONSIGNEE COUNTRY", "DESTINATION COUNTRY", "COUNTRY",
+			"EMPFAENGER LAND", "ZIELLAND", "LAND", "PAYS", "PAIS", "ULKE", "收件人国家", "目的国", "国家",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientCountry },
+	},
+	{
+		Key:   "recipientEmail",
+		Title: "Recipient Email",
+		Kind:  valueKindEmail,
+		Synonyms: []string{
+			"RECEPIENT EMAIL", "RECEIPIENT EMAIL", "RECIPIENT EMAIL", "RECEIVER EMAIL", "CONSIGNEE EMAIL", "EMAIL", "E-MAIL",
+			"EMPFAENGER EMAIL", "EMPFAENGER E-MAIL", "收件人邮箱", "邮箱",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientEmail },
+	},
+	{
+		Key:   "recipientPhone",
+		Title: "Recipient Phone",
+		Kind:  valueKindPhone,
+		Synonyms: []string{
+			"PHONE NUMBER", "PHONE", "TEL", "TELEPHONE", "RECIPIENT PHONE", "RECEIVER PHONE", "CONSIGNEE PHONE",
+			"RECIPIENT TEL", "TELEFON", "TELEFONNUMMER", "EMPFAENGER TELEFON", "收件人电话", "电话", "手机",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientPhone },
+	},
+	{
+		Key:      "recipientEori",
+		Title:    "Recipient EORI Number",
+		Kind:     valueKindEORI,
+		Synonyms: []string{"RECIPIENT EORI", "RECEIVER EORI", "CONSIGNEE EORI", "IMPORTER EORI", "EORI", "EORI NUMBER", "EMPFAENGER EORI"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.RecipientEORI },
+	},
+	{
+		Key:   "recipientVat",
+		Title: "Recipient VAT Number",
+		Kind:  valueKindVAT,
+		Synonyms: []string{
+			"RECIPIENT VAT", "RECEIVER VAT", "CONSIGNEE VAT", "IMPORTER VAT", "VAT NUMBER", "VAT ID", "UST-ID",
+			"USTID", "EMPFAENGER UST-ID", "收件人税号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.RecipientVAT },
+	},
+	{
+		Key:      "notifyName",
+		Title:    "Notify Party Name",
+		Synonyms: []string{"NOTIFY", "NOTIFY PARTY", "NOTIFY NAME", "NOTIFY PARTY NAME", "ALSO NOTIFY", "通知人"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.NotifyName },
+	},
+	{
+		Key:      "notifyEmail",
+		Title:    "Notify Party Email",
+		Kind:     valueKindEmail,
+		Synonyms: []string{"NOTIFY EMAIL", "NOTIFY PARTY EMAIL", "NOTIFY E-MAIL", "通知人邮箱"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.NotifyEmail },
+	},
+	{
+		Key:      "notifyPhone",
+		Title:    "Notify Party Phone",
+		Kind:     valueKindPhone,
+		Synonyms: []string{"NOTIFY PHONE", "NOTIFY PARTY PHONE", "NOTIFY TEL", "通知人电话"},
+		Mapping:  func(s *Schema) *ColumnMapping { return &s.NotifyPhone },
+	},
+	{
+		Key:      "totalShipmentGrossWeight",
+		Title:    "Total Shipment Gross Weight",
+		Required: true,
+		Kind:     valueKindWeight,
+		Synonyms: []string{
+			"GROSS WEIGHT", "GROSS WEIGHT KG", "WEIGHT", "WEIGHT KG", "TOTAL WEIGHT", "PARCEL WEIGHT", "GEWICHT",
+			"BRUTTOGEWICHT", "POIDS", "PESO", "AGIRLIK", "毛重", "重量", "重量 KG",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.TotalShipmentGrossWeight },
+	},
+	{
+		Key:   "itemGrossWeight",
+		Title: "Item Gross Weight",
+		Kind:  valueKindWeight,
+		Synonyms: []string{
+			"ITEM WEIGHT", "ITEM GROSS WEIGHT", "LINE WEIGHT", "ARTICLE WEIGHT", "PRODUCT WEIGHT", "SKU WEIGHT",
+			"ARTIKELGEWICHT", "POIDS ARTICLE", "PESO ARTICULO", "商品重量", "单品重量",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ItemGrossWeight },
+	},
+	{
+		Key:      "itemUnitPriceConcurrency",
+		Title:    "Item Unit Price Currency",
+		Required: true,
+		Kind:     valueKindCurrency,
+		Synonyms: []string{
+			"CURRENCY", "CURRENCY CODE", "CCY", "VALUE CURRENCY", "WAEHRUNG", "DEVISE", "MONNAIE", "MONEDA", "PARA BIRIMI",
+			"币种", "货币",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ItemUnitPriceConcurrency },
+	},
+	{
+		Key:   "productSku",
+		Title: "Product SKU",
+		Synonyms: []string{
+			"SKU NUMBER", "SKU", "SKU NO", "ARTICLE NUMBER", "ITEM NUMBER", "PRODUCT ID", "ARTIKELNUMMER", "REFERENCE ARTICLE",
+			"商品编码", "货号",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ProductSKU },
+	},
+	{
+		Key:      "shipmentGoodsDescription",
+		Title:    "Shipment Goods Description",
+		Required: true,
+		Synonyms: []string{
+			"ITEM DESCRIPTION", "ITEM DESCRIPTION1", "GOODS DESCRIPTION", "DESCRIPTION", "PRODUCT DESCRIPTION",
+			"CONTENT", "CONTENTS", "WARENBESCHREIBUNG", "BESCHREIBUNG", "INHALT", "DESIGNATION", "DESCRIPCION",
+			"ACIKLAMA", "品名", "商品描述", "货物描述", "英文品名",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ShipmentGoodsDescription },
+	},
+	{
+		Key:      "productHSCode",
+		Title:    "Product HS Code",
+		Required: true,
+		Kind:     valueKindHSCode,
+		Synonyms: []string{
+			"ITEM HS CODE", "HS CODE", "HSCODE", "HS", "TARIC", "TARIFF CODE", "TARIFF NUMBER", "COMMODITY CODE",
+			"ZOLLTARIFNUMMER", "WARENTARIFNUMMER", "CODE SH", "GTIP", "海关编码", "HS编码",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ProductHSCode },
+	},
+	{
+		Key:      "itemQuantity",
+		Title:    "Item Quantity",
+		Required: true,
+		Kind:     valueKindQuantity,
+		Synonyms: []string{
+			"ITEM QUANTITY", "QUANTITY", "QTY", "PIECES", "PCS", "MENGE", "ANZAHL", "STUECKZAHL", "QUANTITE", "CANTIDAD",
+			"ADET", "数量", "件数",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ItemQuantity },
+	},
+	{
+		Key:      "itemPrice",
+		Title:    "Item Price",
+		Required: true,
+		Kind:     valueKindPrice,
+		Synonyms: []string{
+			"UNIT VALUE", "UNIT PRICE", "ITEM PRICE", "ITEM VALUE", "PRICE", "VALUE", "DECLARED VALUE", "PREIS",
+			"EINZELPREIS", "WERT", "WARENWERT", "PRIX", "VALEUR", "PRECIO", "VALOR", "FIYAT", "单价", "申报价值", "价格",
+		},
+		Mapping: func(s *Schema) *ColumnMapping { return &s.ItemPrice },
+	},
+}
+
+type headerMatch struct {
+	Field  int
+	Column int
+	Score  float64
+}
+
+// headersToMapping suggests a schema from the header row and, if available, a few sample rows below it.
+func headersToMapping(headers []string, samples [][]string) *SchemaSuggestion {
+	columns := len(headers)
+	for _, row := range samples {
+		columns = max(columns, len(row))
+	}
+
+	normalizedHeaders := make([]string, columns)
+	for i := 0; i < len(headers); i++ {
+		normalizedHeaders[i] = normalizeHeader(headers[i])
+	}
+
+	headerCounts := make(map[string]int)
+	for _, header := range normalizedHeaders {
+		if header != "" {
+			headerCounts[header]++
+		}
+	}
+
+	var matches []headerMatch
+	for fieldIndex, field := range schemaFields {
+		for column, header := range normalizedHeaders {
+			headerScore := 0.0
+			if header != "" {
+				headerScore = scoreHeader(header, field)
+			}
+			score := combineScores(headerScore, contentScore(field.Kind, samples, column), field.Kind, len(samples) > 0)
+			if score >= minSuggestionConfidence {
+				matches = append(matches, headerMatch{Field: fieldIndex, Column: column, Score: score})
+			}
+		}
+	}
+
+	// highest scores win, every field and every column is used at most once
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	suggestion := &SchemaSuggestion{Headers: headers}
+	for _, field := range schemaFields {
+		field.Mapping(&suggestion.Schema).Title = field.Title
+	}
+
+	mappedFields := make(map[int]bool)
+	mappedColumns := make(map[int]bool)
+	for _, match := range matches {
+		if mappedFields[match.Field] || mappedColumns[match.Column] {
+			continue
+		}
+		mappedFields[match.Field] = true
+		mappedColumns[match.Column] = true
+
+		mapping := schemaFields[match.Field].Mapping(&suggestion.Schema)
+		header := ""
+		if match.Column < len(headers) {
+			header = headers[match.Column]
+		}
+		mapping.Content = fmt.Sprintf("$%s:%s", IndexToAlpha(match.Column), header)
+		// binding by header survives columns being inserted or reordered later on
+		if headerCounts[normalizedHeaders[match.Column]] == 1 {
+			mapping.Header = header
+		} else {
+			mapping.Column = Ptr(match.Column)
+		}
+		mapping.Confidence = Ptr(roundConfidence(match.Score))
+		mapping.Samples = columnSamples(samples, match.Column)
+	}
+
+	// most marketplaces name the manifest file after the master waybill
+	if !suggestion.MasterWaybillNumber.bindsColumn() {
+		suggestion.MasterWaybillNumber.Content = "Filename"
+		suggestion.MasterWaybillNumber.UseFilename = Ptr(true)
+	}
+
+	return suggestion
+}
+
+func scoreHeader(normalizedHeader string, field schemaField) float64 {
+	best := 0.0
+	for _, synonym := range field.Synonyms {
+		score := headerSimilarity(normalizedHeader, normalizeHeader(synonym))
+		if score > best {
+			best = score
+		}
+		if best == 1 {
+			break
+		}
+	}
+	return best
+}
+
+// headerSimilarity compares two normalized headers and returns a score between 0 and 1.
+func headerSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	compactA := strings.ReplaceAll(a, " ", "")
+	compactB := strings.ReplaceAll(b, " ", "")
+	if compactA == compactB {
+		return 0.95
+	}
+
+	best := 0.0
+
+	// fuzzy match, catches typos such as RECEIPIENT
+	lenA, lenB := len([]rune(compactA)), len([]rune(compactB))
+	maxLen := max(lenA, lenB)
+	distance := levenshtein(compactA, compactB)
+	best = max(best, 0.9*(1-2*float64(distance)/float64(maxLen)))
+
+	// token overlap, catches reordered or additional words such as "WEIGHT (KG)"
+	tokensA, tokensB := strings.Fields(a), strings.Fields(b)
+	common := 0
+	for _, tokenA := range tokensA {
+		for _, tokenB := range tokensB {
+			if tokenSimilar(tokenA, tokenB) {
+				common++
+				break
+			}
+		}
+	}
+	if common > 0 {
+		union := len(tokensA) + len(tokensB) - common
+		best = max(best, 0.9*float64(common)/float64(union))
+	}
+
+	// containment, needed for languages without word separators
+	if minLen := min(lenA, lenB); minLen >= 2 && (strings.Contains(compactA, compactB) || strings.Contains(compactB, compactA)) {
+		best = max(best, 0.6+0.3*float64(minLen)/float64(maxLen))
+	}
+
+	return best
+}
+
+func tokenSimilar(a, b string) bool {
+	if a == b {
+		return true
+	}
+	maxLen := max(len([]rune(a)), len([]rune(b)))
+	return maxLen >= 5 && levenshtein(a, b) <= maxLen/5
+}
+
+var headerTransliterations = strings.NewReplacer(
+	"Ä", "AE", "Ö", "OE", "Ü", "UE", "ß", "SS",
+	"É", "E", "È", "E", "Ê", "E", "À", "A", "Â", "A", "Á", "A", "Í", "I", "Ó", "O", "Ú", "U", "Ñ", "N", "Ç", "C",
+	"Ğ", "G", "Ş", "S", "İ", "I",
+)
+
+// normalizeHeader upper-cases a header, transliterates common diacritics and replaces punctuation with spaces.
+func normalizeHeader(header string) string {
+	header = headerTransliterations.Replace(strings.ToUpper(strings.TrimSpace(header)))
+
+	var sb strings.Builder
+	for _, r := range header {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func roundConfidence(score float64) float64 {
+	return float64(int(score*100+0.5)) / 100
+}
@@ -0,0 +1,119 @@
+package main
+
+// WaybillSummary counts what a master waybill contains. It is returned by dry runs so that a manifest can be
+// checked before anything is sent to the ONE Record server.
+type WaybillSummary struct {
+	MasterWaybill    string  `json:"masterWaybill"`
+	Carrier          string  `json:"carrier,omitempty"`
+	HouseWaybills    int     `json:"houseWaybills"`
+	Pieces           int     `json:"pieces"`
+	Items            int     `json:"items"`
+	TotalGrossWeight float64 `json:"totalGrossWeight"`
+	WeightUnit       string  `json:"weightUnit"`
+	// DeclaredValue is the sum of quantity times unit price per currency.
+	DeclaredValue map[string]float64 `json:"declaredValue"`
+}
+
+type DryRunResult struct {
+	Waybill *Waybill `json:"waybill,omitempty"`
+	// Objects are the logistics objects built by a template.
+	Objects *PublishedObject  `json:"objects,omitempty"`
+	Summary *WaybillSummary   `json:"summary"`
+	Report  *ValidationReport `json:"report"`
+}
+
+func (w *Waybill) dryRun(report *ValidationReport) (DryRunResult, error) {
+	return DryRunResult{Waybill: w, Summary: summarizeWaybill(w), Report: report}, nil
+}
+
+func (w *TemplateWaybill) dryRun(report *ValidationReport) (DryRunResult, error) {
+	objects, err := w.objects()
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	return DryRunResult{Objects: objects, Summary: summarizeObjects(w.Waybill, objects), Report: report}, nil
+}
+
+func summarizeWaybill(master *Waybill) *WaybillSummary {
+	summary := &WaybillSummary{
+		MasterWaybill: master.FullWaybillNumber(),
+		HouseWaybills: len(master.HouseWaybills),
+		WeightUnit:    unitKilogram,
+		DeclaredValue: make(map[string]float64),
+	}
+	if carrier := airlineByPrefix(master.WaybillPrefix); carrier != nil {
+		summary.Carrier = carrier.Code + " " + carrier.Name
+	}
+
+	for _, house := range master.HouseWaybills {
+		if house.Shipment == nil {
+			continue
+		}
+		if weight := house.Shipment.TotalGrossWeight; weight != nil {
+			summary.TotalGrossWeight += float64(weight.NumericalValue)
+		}
+		summary.Pieces += len(house.Shipment.Pieces)
+		for _, piece := range house.Shipment.Pieces {
+			summary.Items += len(piece.ContainedItems)
+			for _, item := range piece.ContainedItems {
+				if item.ItemQuantity == nil || item.UnitPrice == nil {
+					continue
+				}
+				summary.DeclaredValue[item.UnitPrice.CurrencyUnit] += float64(item.ItemQuantity.NumericalValue * item.UnitPrice.NumericalValue)
+			}
+		}
+	}
+
+	summary.TotalGrossWeight = roundTo(summary.TotalGrossWeight, 3)
+	for currency, value := range summary.DeclaredValue {
+		summary.DeclaredValue[currency] = roundTo(value, 2)
+	}
+	return summary
+}
+
+// summarizeObjects counts the logistics objects built by a template.
+func summarizeObjects(master *Waybill, root *PublishedObject) *WaybillSummary {
+	summary := &WaybillSummary{
+		MasterWaybill: master.FullWaybillNumber(),
+		HouseWaybills: len(root.Links["cargo:houseWaybills"]),
+		WeightUnit:    unitKilogram,
+		DeclaredValue: make(map[string]float64),
+	}
+	if carrier := airlineByPrefix(master.WaybillPrefix); carrier != nil {
+		summary.Carrier = carrier.Code + " " + carrier.Name
+	}
+
+	for _, house := range root.Links["cargo:houseWaybills"] {
+		for _, shipment := range house.Links["cargo:shipment"] {
+			if weight, ok := numericalValue(shipment.Body, "cargo:totalGrossWeight"); ok {
+				summary.TotalGrossWeight += weight
+			}
+			summary.Pieces += len(shipment.Links["cargo:pieces"])
+			for _, piece := range shipment.Links["cargo:pieces"] {
+				summary.Items += len(piece.Links["cargo:containedItems"])
+				for _, item := range piece.Links["cargo:containedItems"] {
+					quantity, hasQuantity := numericalValue(item.Body, "cargo:itemQuantity")
+					price, hasPrice := numericalValue(item.Body, "cargo:unitPrice")
+					unitPrice, _ := item.Body["cargo:unitPrice"].(map[string]any)
+					currency, _ := unitPrice["cargo:currencyUnit"].(string)
+					if hasQuantity && hasPrice {
+						summary.DeclaredValue[currency] += quantity * price
+					}
+				}
+			}
+		}
+	}
+
+	summary.TotalGrossWeight = roundTo(summary.TotalGrossWeight, 3)
+	for currency, value := range summary.DeclaredValue {
+		summary.DeclaredValue[currency] = roundTo(value, 2)
+	}
+	return summary
+}
+
+// numericalValue returns the number of a Value or CurrencyValue property.
+func numericalValue(body map[string]any, property string) (float64, bool) {
+	value, _ := body[property].(map[string]any)
+	number, ok := value["cargo:numericalValue"].(float64)
+	return number, ok
+}
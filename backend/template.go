@@ -0,0 +1,538 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// A pipeline with a template builds the logistics objects of each house waybill from a JSON-LD template such as
+// documentation/one-record/eCommerce.json instead of the fields of the schema. String values of the template are
+// filled from the cells of a row:
+//
+//	"$A:TRACKING NO."           the column with the header TRACKING NO., column A if there is no such header
+//	"$AB"                       column AB
+//	"=concat($M, \" \", $N)"    an expression, see expression.go
+//
+// Other values are copied as they are, terms without prefix are cargo terms. An object is left out if all of its
+// placeholders are empty. Rows with the same waybillNumber belong to one house waybill: logistics objects such as
+// the shipment are merged, each row adds its own logistics objects to arrays, e.g. an item per row, and embedded
+// objects are added unless an equal one is there. The elements of an array are grouped by "$key", e.g.
+// "$key": "$C:Box Number" in a piece packs the items of the rows with the same box number into one piece.
+//
+// The master waybill is taken from the waybill mapping of the schema, masterWaybill in the template is ignored.
+
+// templateKey is the property of an array element of the template that groups the rows.
+const templateKey = "$key"
+
+var templatePlaceholderPattern = regexp.MustCompile(`^\$([A-Za-z]{1,3})(?::(.*))?$`)
+
+// logisticsObjectTypes are the types that are published as logistics objects of their own, objects of other types
+// are embedded in them.
+var logisticsObjectTypes = map[string]bool{
+	"cargo:Waybill":  true,
+	"cargo:Shipment": true,
+	"cargo:Piece":    true,
+	"cargo:Item":     true,
+	"cargo:Product":  true,
+}
+
+// templatePropertyTypes are the types of objects that the template may leave without @type.
+var templatePropertyTypes = map[string]string{
+	"cargo:shipment":       "cargo:Shipment",
+	"cargo:pieces":         "cargo:Piece",
+	"cargo:containedItems": "cargo:Item",
+	"cargo:ofProduct":      "cargo:Product",
+}
+
+// template is a compiled template that is bound to the columns of a manifest.
+type template struct {
+	root *templateObject
+}
+
+type templateNode interface {
+	// fill returns the value for a row, nil if it is empty, and whether a placeholder in it has a value.
+	fill(row *templateRow) (any, bool)
+	// placeholders reports whether the value depends on the row.
+	placeholders() bool
+}
+
+// templateRow collects the invalid values of a row while it fills the template.
+type templateRow struct {
+	columns          []string
+	decimalSeparator string
+	errors           []RowError
+}
+
+type templateConstant struct {
+	value any
+}
+
+func (c *templateConstant) fill(*templateRow) (any, bool) { return c.value, false }
+
+func (c *templateConstant) placeholders() bool { return false }
+
+// templatePlaceholder takes a value from a column or computes it with an expression. Numerical values are
+// converted to numbers.
+type templatePlaceholder struct {
+	path       string
+	column     int
+	expression *expression
+	numerical  bool
+}
+
+func (p *templatePlaceholder) fill(row *templateRow) (any, bool) {
+	value := ""
+	switch {
+	case p.expression != nil:
+		value = p.expression.eval(row.columns)
+	case p.column < len(row.columns):
+		value = strings.TrimSpace(row.columns[p.column])
+	}
+	if value == "" {
+		return nil, false
+	}
+	if !p.numerical {
+		return value, true
+	}
+	number, ok := parseNumber(value, row.decimalSeparator)
+	if !ok {
+		number, ok = parsePrice(value, row.decimalSeparator)
+	}
+	if !ok {
+		row.errors = append(row.errors, RowError{Field: p.path, Value: value, Reason: numberReason(value, row.decimalSeparator, "not a number")})
+		return nil, false
+	}
+	return number, true
+}
+
+func (p *templatePlaceholder) placeholders() bool { return true }
+
+type templateProperty struct {
+	name string
+	node templateNode
+}
+
+type templateObject struct {
+	properties []templateProperty
+	key        templateNode
+}
+
+func (o *templateObject) fill(row *templateRow) (any, bool) {
+	object := make(map[string]any, len(o.properties))
+	filled := false
+	for _, property := range o.properties {
+		value, ok := property.node.fill(row)
+		if value != nil {
+			object[property.name] = value
+		}
+		filled = filled || ok
+	}
+	if !filled && o.placeholders() {
+		return nil, false
+	}
+	if o.key != nil {
+		if key, _ := o.key.fill(row); key != nil {
+			object[templateKey] = fmt.Sprint(key)
+		}
+	}
+	return object, filled
+}
+
+func (o *templateObject) placeholders() bool {
+	for _, property := range o.properties {
+		if property.node.placeholders() {
+			return true
+		}
+	}
+	return false
+}
+
+type templateArray struct {
+	elements []templateNode
+}
+
+func (a *templateArray) fill(row *templateRow) (any, bool) {
+	var values []any
+	filled := false
+	for _, element := range a.elements {
+		value, ok := element.fill(row)
+		if value != nil {
+			values = append(values, value)
+		}
+		filled = filled || ok
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values, filled
+}
+
+func (a *templateArray) placeholders() bool {
+	return slices.ContainsFunc(a.elements, templateNode.placeholders)
+}
+
+// compileTemplate checks a template and binds its placeholders to the columns of a manifest. It only checks the
+// template if headerIndex is nil, e.g. when the pipeline is saved.
+func compileTemplate(source map[string]any, headerIndex map[string]int, lookups map[string]map[string]string) (*template, error) {
+	compiler := &templateCompiler{headerIndex: headerIndex, lookups: lookups}
+	root, err := compiler.object("", source, "cargo:Waybill")
+	if err != nil {
+		return nil, err
+	}
+	if root.typ() != "cargo:Waybill" {
+		return nil, fmt.Errorf("template must describe a Waybill, not %s", root.typ())
+	}
+	if !slices.ContainsFunc(root.properties, func(property templateProperty) bool {
+		return property.name == "cargo:waybillNumber" && property.node.placeholders()
+	}) {
+		return nil, fmt.Errorf("waybillNumber of the template must be a placeholder")
+	}
+	return &template{root: root}, nil
+}
+
+// typ returns the @type of the object.
+func (o *templateObject) typ() string {
+	for _, property := range o.properties {
+		if constant, ok := property.node.(*templateConstant); ok && property.name == "@type" {
+			typ, _ := constant.value.(string)
+			return typ
+		}
+	}
+	return ""
+}
+
+type templateCompiler struct {
+	headerIndex map[string]int
+	lookups     map[string]map[string]string
+}
+
+// object compiles an object of the template, defaultType is the type of the property it is the value of.
+func (c *templateCompiler) object(path string, source map[string]any, defaultType string) (*templateObject, error) {
+	object := &templateObject{}
+	names := make([]string, 0, len(source))
+	for name := range source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := source[name]
+		switch {
+		case name == "@context":
+			continue
+		case name == templateKey:
+			key, err := c.value(path+"."+name, name, value)
+			if err != nil {
+				return nil, err
+			}
+			object.key = key
+			continue
+		case path == "" && templateTerm(name) == "cargo:masterWaybill":
+			continue
+		}
+
+		term := templateTerm(name)
+		propertyPath := strings.TrimPrefix(path+"."+strings.TrimPrefix(term, "cargo:"), ".")
+		if name == "@type" {
+			typ, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: @type must be a string", propertyPath)
+			}
+			value = templateTerm(typ)
+		}
+		node, err := c.value(propertyPath, term, value)
+		if err != nil {
+			return nil, err
+		}
+		object.properties = append(object.properties, templateProperty{name: term, node: node})
+	}
+
+	if object.typ() == "" && defaultType != "" {
+		object.properties = append([]templateProperty{{name: "@type", node: &templateConstant{value: defaultType}}}, object.properties...)
+	}
+	return object, nil
+}
+
+func (c *templateCompiler) value(path, term string, value any) (templateNode, error) {
+	switch value := value.(type) {
+	case map[string]any:
+		return c.object(path, value, templatePropertyTypes[term])
+	case []any:
+		array := &templateArray{}
+		for _, element := range value {
+			node, err := c.value(path, term, element)
+			if err != nil {
+				return nil, err
+			}
+			array.elements = append(array.elements, node)
+		}
+		return array, nil
+	case string:
+		return c.placeholder(path, term, value)
+	default:
+		return &templateConstant{value: value}, nil
+	}
+}
+
+func (c *templateCompiler) placeholder(path, term, value string) (templateNode, error) {
+	placeholder := &templatePlaceholder{path: path, numerical: term == "cargo:numericalValue"}
+	if source, ok := strings.CutPrefix(value, "="); ok {
+		expression, err := parseExpression(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid expression: %w", path, err)
+		}
+		if c.headerIndex != nil {
+			err = expression.bind(c.headerIndex, c.lookups)
+		} else {
+			for _, table := range expression.tables {
+				if _, ok := c.lookups[table.name]; !ok {
+					err = fmt.Errorf("no lookup table %q", table.name)
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		placeholder.expression = expression
+		return placeholder, nil
+	}
+
+	match := templatePlaceholderPattern.FindStringSubmatch(value)
+	if match == nil {
+		return &templateConstant{value: value}, nil
+	}
+	column, err := AlphaToIndex(match[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if index, ok := c.headerIndex[normalizeHeader(match[2])]; ok && match[2] != "" {
+		column = index
+	}
+	placeholder.column = column
+	return placeholder, nil
+}
+
+// templateTerm turns a term of the template such as "waybillNumber" or "Waybill" into a cargo term.
+func templateTerm(term string) string {
+	if strings.HasPrefix(term, "@") || strings.Contains(term, ":") {
+		return term
+	}
+	return "cargo:" + term
+}
+
+// TemplateWaybill is a master waybill with house waybills built from a template.
+type TemplateWaybill struct {
+	*Waybill
+	Houses map[HouseWaybillNumber]map[string]any
+}
+
+// templateToOneRecord converts the rows of a manifest into house waybills with the template of the schema. Invalid
+// values are handled like in excelToOneRecord.
+func templateToOneRecord(pipeline *Schema, rows *Table, doc *Document, progress *JobProgress) (*TemplateWaybill, *ValidationReport, error) {
+	waybill := &TemplateWaybill{Waybill: NewMasterWaybill(), Houses: make(map[HouseWaybillNumber]map[string]any)}
+
+	skipInvalid := pipeline.OnInvalidRow == InvalidRowSkip
+	report := &ValidationReport{Policy: InvalidRowReject, Errors: []RowError{}}
+	if skipInvalid {
+		report.Policy = InvalidRowSkip
+	}
+	invalidHouseWaybills := make(map[HouseWaybillNumber]bool)
+
+	masterWaybillReader, err := newMasterWaybillReader(waybill.Waybill, pipeline.MasterWaybillNumber, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for rows.Next() {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		progress.rowRead()
+
+		row := &templateRow{columns: columns, decimalSeparator: pipeline.DecimalSeparator}
+		value, _ := pipeline.template.root.fill(row)
+		house, _ := value.(map[string]any)
+		houseWaybillNumber, _ := house["cargo:waybillNumber"].(string)
+		if houseWaybillNumber == "" {
+			continue
+		}
+		report.Rows++
+
+		if len(row.errors) > 0 {
+			for i := range row.errors {
+				row.errors[i].Row = rows.Row()
+				row.errors[i].HouseWaybill = houseWaybillNumber
+			}
+			report.Errors = append(report.Errors, row.errors...)
+			progress.setErrors(len(report.Errors))
+			if skipInvalid && !invalidHouseWaybills[HouseWaybillNumber(houseWaybillNumber)] {
+				report.SkippedHouseWaybills = append(report.SkippedHouseWaybills, houseWaybillNumber)
+			}
+			invalidHouseWaybills[HouseWaybillNumber(houseWaybillNumber)] = true
+			delete(waybill.Houses, HouseWaybillNumber(houseWaybillNumber))
+			progress.setHouseWaybills(len(waybill.Houses))
+			continue
+		}
+		if invalidHouseWaybills[HouseWaybillNumber(houseWaybillNumber)] {
+			continue
+		}
+
+		if err := masterWaybillReader.read(columns, rows.Row()); err != nil {
+			return nil, nil, err
+		}
+
+		if previous := waybill.Houses[HouseWaybillNumber(houseWaybillNumber)]; previous != nil {
+			mergeTemplateObject(previous, house)
+		} else {
+			waybill.Houses[HouseWaybillNumber(houseWaybillNumber)] = house
+			progress.setHouseWaybills(len(waybill.Houses))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read manifest after row %d: %w", rows.Row(), err)
+	}
+	if warning := rows.trailingRowsWarning(); warning != "" {
+		report.Warnings = append(report.Warnings, warning)
+	}
+
+	report.HouseWaybills = len(waybill.Houses)
+	if len(report.Errors) > 0 && (!skipInvalid || report.HouseWaybills == 0) {
+		return nil, report, report
+	}
+	if err := masterWaybillReader.finish(report); err != nil {
+		return nil, nil, err
+	}
+	return waybill, report, nil
+}
+
+// mergeTemplateObject adds the values of a later row of the same house waybill to an object. Values of embedded
+// objects and literals are kept from the first row.
+func mergeTemplateObject(object, row map[string]any) {
+	for name, value := range row {
+		previous, ok := object[name]
+		if !ok {
+			object[name] = value
+			continue
+		}
+		switch value := value.(type) {
+		case map[string]any:
+			if previous, ok := previous.(map[string]any); ok && isLogisticsObject(value) {
+				mergeTemplateObject(previous, value)
+			}
+		case []any:
+			if previous, ok := previous.([]any); ok {
+				object[name] = mergeTemplateArray(previous, value)
+			}
+		}
+	}
+}
+
+func mergeTemplateArray(elements, row []any) []any {
+	for _, value := range row {
+		if object, ok := value.(map[string]any); ok {
+			if key, ok := object[templateKey]; ok {
+				index := slices.IndexFunc(elements, func(element any) bool {
+					previous, ok := element.(map[string]any)
+					return ok && previous[templateKey] == key
+				})
+				if index >= 0 {
+					mergeTemplateObject(elements[index].(map[string]any), object)
+					continue
+				}
+			}
+			if isLogisticsObject(object) {
+				elements = append(elements, object)
+				continue
+			}
+		}
+		if !slices.ContainsFunc(elements, func(element any) bool { return reflect.DeepEqual(element, value) }) {
+			elements = append(elements, value)
+		}
+	}
+	return elements
+}
+
+func isLogisticsObject(object map[string]any) bool {
+	typ, _ := object["@type"].(string)
+	return logisticsObjectTypes[typ]
+}
+
+func (w *TemplateWaybill) objects() (*PublishedObject, error) {
+	body := *w.Waybill
+	body.HouseWaybills = nil
+	object, err := newPublishedObject(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]HouseWaybillNumber, 0, len(w.Houses))
+	for number := range w.Houses {
+		numbers = append(numbers, number)
+	}
+	slices.Sort(numbers)
+	for _, number := range numbers {
+		object.link("cargo:houseWaybills", templateObjects(w.Houses[number]))
+	}
+	return object, nil
+}
+
+// templateObjects splits a filled template into logistics objects.
+func templateObjects(value map[string]any) *PublishedObject {
+	object := &PublishedObject{Body: make(map[string]any, len(value))}
+	object.Type, _ = value["@type"].(string)
+	for name, value := range value {
+		switch value := value.(type) {
+		case map[string]any:
+			if isLogisticsObject(value) {
+				object.link(name, templateObjects(value))
+				continue
+			}
+		case []any:
+			if slices.ContainsFunc(value, func(element any) bool {
+				linked, ok := element.(map[string]any)
+				return ok && isLogisticsObject(linked)
+			}) {
+				for _, element := range value {
+					if element, ok := element.(map[string]any); ok {
+						object.link(name, templateObjects(element))
+					}
+				}
+				continue
+			}
+		}
+		if name != templateKey {
+			object.Body[name] = withoutTemplateKeys(value)
+		}
+	}
+	return object
+}
+
+// withoutTemplateKeys returns a copy of an embedded value without the $key properties.
+func withoutTemplateKeys(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for name, value := range value {
+			if name != templateKey {
+				copied[name] = withoutTemplateKeys(value)
+			}
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(value))
+		for i, element := range value {
+			copied[i] = withoutTemplateKeys(element)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplatePlaceholders(t *testing.T) {
+	headers := []string{"Tracking No.", "Name", "Weight", "Country"}
+	lookups := map[string]map[string]string{"countries": {"Germany": "DE"}}
+
+	tests := []struct {
+		name             string
+		value            any
+		columns          []string
+		decimalSeparator string
+		want             any
+		errors           []string
+	}{
+		{"column letter", "$B", []string{"T1", "Max"}, "", "Max", nil},
+		{"header", "$D:Country", []string{"T1", "", "", "DE"}, "", "DE", nil},
+		{"header with other punctuation", "$A:TRACKING NO", []string{"T1"}, "", "T1", nil},
+		{"letter if header is missing", "$B:Receiver", []string{"T1", "Max"}, "", "Max", nil},
+		{"header before letter", "$Z:Name", []string{"T1", "Max"}, "", "Max", nil},
+		{"trimmed", "$B", []string{"T1", "  Max "}, "", "Max", nil},
+		{"missing column", "$E", []string{"T1"}, "", nil, nil},
+		{"empty cell", "$B", []string{"T1", ""}, "", nil, nil},
+		{"not a placeholder", "$100", []string{"T1"}, "", "$100", nil},
+		{"constant", "Lamp", []string{"T1"}, "", "Lamp", nil},
+		{"expression", `=concat($B, " (", ${Country}, ")")`, []string{"T1", "Max", "", "DE"}, "", "Max (DE)", nil},
+		{"lookup", "=lookup(countries, $D)", []string{"T1", "", "", "germany"}, "", "DE", nil},
+		{"number", map[string]any{"numericalValue": "$C"}, []string{"T1", "", "1,5"}, "", map[string]any{"@type": "cargo:Value", "cargo:numericalValue": 1.5}, nil},
+		{"number with separator", map[string]any{"numericalValue": "$C"}, []string{"T1", "", "1.250"}, ",", map[string]any{"@type": "cargo:Value", "cargo:numericalValue": 1250.0}, nil},
+		{"price", map[string]any{"numericalValue": "$C"}, []string{"T1", "", "€ 12,50"}, "", map[string]any{"@type": "cargo:Value", "cargo:numericalValue": 12.5}, nil},
+		{"not a number", map[string]any{"numericalValue": "$C"}, []string{"T1", "", "heavy"}, "", nil, []string{"grossWeight.numericalValue: not a number"}},
+		{"ambiguous number", map[string]any{"numericalValue": "$C"}, []string{"T1", "", "1,250"}, "", nil, []string{"grossWeight.numericalValue: ambiguous separator, set the decimal separator of the pipeline"}},
+		{"misplaced separator", map[string]any{"numericalValue": "$C"}, []string{"T1", "", "1.5"}, ",", nil, []string{`grossWeight.numericalValue: ambiguous separator, the decimal separator of the pipeline is ","`}},
+		{"empty object", map[string]any{"numericalValue": "$C", "unit": "KGM"}, []string{"T1"}, "", nil, nil},
+		{"object with constants", map[string]any{"numericalValue": 2.0, "unit": "KGM"}, []string{"T1"}, "", map[string]any{"@type": "cargo:Value", "cargo:numericalValue": 2.0, "cargo:unit": "KGM"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if object, ok := test.value.(map[string]any); ok {
+				object["@type"] = "Value"
+			}
+			schema := &Schema{
+				Template: map[string]any{
+					"@type":         "Waybill",
+					"waybillNumber": "$A",
+					"grossWeight":   test.value,
+				},
+				Lookups: lookups,
+			}
+			resolved, err := schema.resolveColumns(headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			row := &templateRow{columns: test.columns, decimalSeparator: test.decimalSeparator}
+			value, _ := resolved.template.root.fill(row)
+			got := value.(map[string]any)["cargo:grossWeight"]
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+			var errors []string
+			for _, rowErr := range row.errors {
+				errors = append(errors, rowErr.Field+": "+rowErr.Reason)
+			}
+			if !reflect.DeepEqual(errors, test.errors) {
+				t.Errorf("got errors %q, want %q", errors, test.errors)
+			}
+		})
+	}
+}
+
+func TestCompileTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template map[string]any
+		err      string
+	}{
+		{"valid", map[string]any{"@type": "Waybill", "waybillNumber": "$A"}, ""},
+		{"default type", map[string]any{"waybillNumber": "$A"}, ""},
+		{"other type", map[string]any{"@type": "Shipment", "waybillNumber": "$A"}, "template must describe a Waybill, not cargo:Shipment"},
+		{"constant waybill number", map[string]any{"waybillNumber": "T1"}, "waybillNumber of the template must be a placeholder"},
+		{"invalid letters", map[string]any{"waybillNumber": "$A", "goodsDescription": "$ZZZ"}, `goodsDescription: column "ZZZ" is out of range`},
+		{"invalid expression", map[string]any{"waybillNumber": "=upper($A"}, "waybillNumber: invalid expression: at 8: missing ')'"},
+		{"unknown lookup table", map[string]any{"waybillNumber": "=lookup(codes, $A)"}, `waybillNumber: no lookup table "codes"`},
+		{"type not a string", map[string]any{"waybillNumber": "$A", "shipment": map[string]any{"@type": 1}}, "shipment.@type: @type must be a string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileTemplate(test.template, nil, nil)
+			if got := errorString(err); got != test.err {
+				t.Errorf("got error %q, want %q", got, test.err)
+			}
+		})
+	}
+}
+
+// TestShippedTemplate renders a row with the example pipeline, it must only contain placeholders and real constants.
+func TestShippedTemplate(t *testing.T) {
+	data, err := os.ReadFile("pipelines/ecommerce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pipeline Pipeline
+	if err := json.Unmarshal(data, &pipeline); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePipeline(&pipeline); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := []string{
+		"TRACKING NO.", "CUSTOMER REF", "Box Number", "SENDER NAME", "SHIPPER ADD 1", "SHIPPER ADD 2", "SHIPPER ADD 3",
+		"SENDER CITY", "Ship State", "SENDER POSTCODE", "SENDER COUNTRY", "RECEIPIENT NAME", "RECEIPIENT ADD 1",
+		"RECEIPIENT ADD 2", "RECEIPIENT ADD 3", "RECEIPIENT CITY", "RECEIPIENT COUNTY", "RECEIPIENT POSTCODE",
+		"RECEPIENT EMAIL", "PHONE NUMBER", "GROSS WEIGHT (KG)", "", "Currency", "SKU NUMBER", "ITEM DESCRIPTION1",
+		"ITEM HS CODE", "ITEM QUANTITY", "UNIT VALUE",
+	}
+	columns := []string{
+		"TRK1", "REF1", "BOX1", "Shenzhen Trading", "1 Nanshan Rd", "", "", "Shenzhen", "GD", "518000", "CN",
+		"Max Muster", "Hauptstr. 1", "", "", "Berlin", "BE", "10115", "max@example.com", "+4930123", "1,5", "",
+		"EUR", "SKU1", "Phone case", "392690", "2", "4,99",
+	}
+	resolved, err := pipeline.Mapping.resolveColumns(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &templateRow{columns: columns}
+	value, _ := resolved.template.root.fill(row)
+	if len(row.errors) > 0 {
+		t.Fatalf("got errors %v", row.errors)
+	}
+
+	var check func(path string, value any)
+	check = func(path string, value any) {
+		switch value := value.(type) {
+		case map[string]any:
+			for key, property := range value {
+				check(path+"."+key, property)
+			}
+		case []any:
+			for _, element := range value {
+				check(path, element)
+			}
+		case string:
+			if strings.HasPrefix(value, "$") || strings.Contains(value, "LLM:") || strings.Contains(value, "Manual Input") {
+				t.Errorf("%s: %q is neither a placeholder nor a constant", path, value)
+			}
+		}
+	}
+	check("waybill", value)
+
+	waybill := value.(map[string]any)
+	if waybill["cargo:waybillNumber"] != "TRK1" {
+		t.Errorf("got waybill number %v, want TRK1", waybill["cargo:waybillNumber"])
+	}
+	rendered, err := json.Marshal(waybill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{`"BE"`, `"max@example.com"`, `"Phone case"`} {
+		if !strings.Contains(string(rendered), value) {
+			t.Errorf("%s is not in the waybill", value)
+		}
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
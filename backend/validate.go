@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// InvalidRowPolicy decides what happens to a manifest that contains invalid rows.
+type InvalidRowPolicy string
+
+const (
+	// InvalidRowReject rejects the whole file if any row is invalid. This is the default.
+	InvalidRowReject InvalidRowPolicy = "reject"
+	// InvalidRowSkip drops the house waybills that have an invalid row and sends the rest.
+	InvalidRowSkip InvalidRowPolicy = "skip"
+)
+
+func (p InvalidRowPolicy) valid() bool {
+	return p == "" || p == InvalidRowReject || p == InvalidRowSkip
+}
+
+type RowError struct {
+	Row          int    `json:"row"`
+	HouseWaybill string `json:"houseWaybill,omitempty"`
+	Field        string `json:"field"`
+	Value        string `json:"value"`
+	Reason       string `json:"reason"`
+}
+
+// ValidationReport summarizes the rows of a manifest that could not be converted.
+type ValidationReport struct {
+	Policy               InvalidRowPolicy `json:"policy"`
+	Rows                 int              `json:"rows"`
+	HouseWaybills        int              `json:"houseWaybills"`
+	SkippedHouseWaybills []string         `json:"skippedHouseWaybills,omitempty"`
+	Errors               []RowError       `json:"errors"`
+	Warnings             []string         `json:"warnings,omitempty"`
+}
+
+func (r *ValidationReport) Error() string {
+	if len(r.Errors) == 0 {
+		return "no invalid rows"
+	}
+	first := r.Errors[0]
+	return fmt.Sprintf("%d invalid values, first in row %d, %s %q: %s", len(r.Errors), first.Row, first.Field, first.Value, first.Reason)
+}
+
+// cell returns the value of the mapping for one row. Columns beyond the end of the row read as empty,
+// spreadsheets usually omit trailing empty cells. The pattern of the mapping is applied to the value.
+func cell(columns []string, mapping ColumnMapping, doc *Document) string {
+	value := ""
+	switch {
+	case mapping.expression != nil:
+		value = mapping.expression.eval(columns)
+	case mapping.Column != nil:
+		if *mapping.Column < len(columns) {
+			value = strings.TrimSpace(columns[*mapping.Column])
+		}
+	case mapping.Constant != nil:
+		value = *mapping.Constant
+	case doc == nil:
+	case mapping.UseFilename != nil && *mapping.UseFilename:
+		value = doc.Filename
+	case mapping.UseSheetName != nil && *mapping.UseSheetName:
+		value = doc.Sheet
+	case mapping.Cells != "":
+		value = doc.cells(mapping.Cells)
+	}
+
+	if mapping.Pattern != "" && value != "" {
+		pattern, err := compilePattern(mapping.Pattern)
+		if err != nil {
+			return ""
+		}
+		value = strings.TrimSpace(extractPattern(pattern, value))
+	}
+	return value
+}
+
+// validateRow checks the values of one row against the required fields and the kind of each field.
+// Values taken from the document are the same for every row and are not checked here.
+func validateRow(schema *Schema, columns []string, row int) []RowError {
+	var rowErrors []RowError
+	for _, field := range schemaFields {
+		mapping := field.Mapping(schema)
+		if mapping.fromDocument() {
+			continue
+		}
+		value := cell(columns, *mapping, nil)
+		// the value before the pattern is applied
+		unextracted := *mapping
+		unextracted.Pattern = ""
+		raw := cell(columns, unextracted, nil)
+
+		reason := ""
+		switch {
+		case value == "" && field.Required && mapping.Column != nil && *mapping.Column >= len(columns):
+			reason = fmt.Sprintf("row has %d columns, column %s is missing", len(columns), IndexToAlpha(*mapping.Column))
+		case value == "" && raw != "":
+			reason = fmt.Sprintf("value does not match pattern %s", mapping.Pattern)
+			value = raw
+		case value == "" && field.Required:
+			reason = "required value is empty"
+		case value != "":
+			reason = field.Kind.validate(value, schema.DecimalSeparator)
+		}
+		if reason != "" {
+			rowErrors = append(rowErrors, RowError{Row: row, Field: field.Key, Value: value, Reason: reason})
+		}
+	}
+	return rowErrors
+}
+
+// validate returns why the value is not valid for the kind or "" if it is. decimalSeparator is that of the pipeline.
+func (k valueKind) validate(value, decimalSeparator string) string {
+	switch k {
+	case valueKindAWB:
+		return checkAirWaybillNumber(strings.NewReplacer("-", "", " ", "").Replace(value))
+	case valueKindHSCode:
+		if !hsCodePattern.MatchString(strings.ReplaceAll(value, " ", "")) {
+			return "HS code must have 6, 8 or 10 digits"
+		}
+	case valueKindCountry:
+		if _, ok := normalizeCountry(value); !ok {
+			return "not an ISO 3166 country code or country name"
+		}
+	case valueKindCurrency:
+		if !isISOCurrencyCode(value) {
+			return "not an ISO 4217 currency code"
+		}
+	case valueKindWeight:
+		if _, ok := parseWeight(value, unitKilogram, decimalSeparator); !ok {
+			return numberReason(value, decimalSeparator, "not a weight")
+		}
+	case valueKindQuantity:
+		if quantity, ok := parseQuantity(value, decimalSeparator); !ok || quantity <= 0 || quantity != math.Trunc(quantity) {
+			return numberReason(value, decimalSeparator, "not a positive whole number")
+		}
+	case valueKindPrice:
+		if _, ok := parsePrice(value, decimalSeparator); !ok {
+			return numberReason(value, decimalSeparator, "not a price")
+		}
+	case valueKindEmail:
+		if !emailPattern.MatchString(value) {
+			return "not an email address"
+		}
+	case valueKindPhone:
+		if !phonePattern.MatchString(value) || countDigits(value) < 5 {
+			return "not a phone number"
+		}
+	case valueKindEORI:
+		if !eoriPattern.MatchString(normalizeTaxIdentifier(value)) {
+			return "not an EORI number"
+		}
+	case valueKindVAT:
+		if !vatPattern.MatchString(normalizeTaxIdentifier(value)) {
+			return "not a VAT identification number"
+		}
+	case valueKindIOSS:
+		if !iossPattern.MatchString(normalizeTaxIdentifier(value)) {
+			return "not an IOSS number"
+		}
+	}
+	return ""
+}
@@ -101,7 +101,7 @@ const ConfirmSchemaPage = () => {
           )}
           <div className="grid grid-cols-1 sm:grid-cols-2 lg:grid-cols-4 gap-8">
             {Object.entries(schema).map(([key, card]) => (
-              key !== 'headers' && (
+              key !== 'headers' && key !== 'layout' && (
                 <Card key={key} className="w-[300px] h-[350px]">
                   <CardHeader className={`h-[75px] rounded-t-lg bg-gray-400 bg-opacity-55 text-gray-200`}>
                     <CardTitle>{card.title}</CardTitle>
@@ -110,6 +110,9 @@ const ConfirmSchemaPage = () => {
                     <div className="flex flex-col justify-between h-full">
                       <p className="font-bold text-lg mt-4">mapped value:</p>
                       <p  dangerouslySetInnerHTML={{ __html: card.content }}></p>
+                      {card.samples && card.samples.length > 0 && (
+                        <p className="text-sm text-gray-400 truncate">e.g. {card.samples.join(', ')}</p>
+                      )}
                       <p className="font-bold text-lg mt-8">corrected value:</p>
                       <Select
                         options={dropDownItems}